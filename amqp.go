@@ -0,0 +1,128 @@
+package gonmap
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+)
+
+// amqpHeader AMQP 0-9-1 协议头
+var amqpHeader = []byte("AMQP\x00\x00\x09\x01")
+
+// amqpMaxFrame Connection.Start 帧的最大长度 超过时不再读取
+const amqpMaxFrame = 64 * 1024
+
+var errAMQPTable = errors.New("amqp field table malformed")
+
+// amqpDetector 发送协议头并解析 Connection.Start 中的 server-properties
+type amqpDetector struct{}
+
+func (amqpDetector) Name() string       { return "AMQPConnectionStart" }
+func (amqpDetector) Protocol() Protocol { return TCP }
+func (amqpDetector) Ports() []int       { return []int{5672} }
+func (amqpDetector) SSLPorts() []int    { return []int{5671} }
+func (amqpDetector) Services() []string { return []string{"amqp"} }
+func (amqpDetector) Rarity() int        { return 8 }
+
+func (amqpDetector) Detect(ctx context.Context, conn net.Conn, target Target) (*MatchResult, error) {
+	if _, err := conn.Write(amqpHeader); err != nil {
+		return nil, err
+	}
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	// 服务端不支持请求的版本时回复自己支持的协议头
+	if bytes.HasPrefix(header, []byte("AMQP")) {
+		return &MatchResult{Service: "amqp", Info: "protocol mismatch", Response: header}, nil
+	}
+	// 帧头 type(1) channel(2) size(4) 之后是 class-id method-id
+	size := binary.BigEndian.Uint32(header[3:7])
+	if header[0] != 1 || size < 6 || size > amqpMaxFrame {
+		return nil, nil
+	}
+	payload := make([]byte, size+1)
+	payload[0] = header[7]
+	if _, err := io.ReadFull(conn, payload[1:]); err != nil {
+		return nil, err
+	}
+	response := append(header[:7:7], payload...)
+	if binary.BigEndian.Uint16(payload[0:2]) != 10 || binary.BigEndian.Uint16(payload[2:4]) != 10 {
+		return nil, nil
+	}
+	result := &MatchResult{Service: "amqp", Response: response}
+	// 表格解析失败时保留已经解析的字段
+	properties, err := amqpFieldTable(payload[6:])
+	result.Product = properties["product"]
+	result.Version = properties["version"]
+	result.Info = properties["platform"]
+	return result, err
+}
+
+// amqpFieldTable 解析 field table 只保留字符串类型的值
+func amqpFieldTable(data []byte) (map[string]string, error) {
+	if len(data) < 4 {
+		return nil, errAMQPTable
+	}
+	size := binary.BigEndian.Uint32(data)
+	if uint64(size) > uint64(len(data)-4) {
+		return nil, errAMQPTable
+	}
+	data = data[4 : 4+size]
+	values := make(map[string]string)
+	for len(data) > 0 {
+		nameLen := int(data[0])
+		if len(data) < 2+nameLen {
+			return values, errAMQPTable
+		}
+		name := string(data[1 : 1+nameLen])
+		kind := data[1+nameLen]
+		data = data[2+nameLen:]
+		n, err := amqpFieldSize(kind, data)
+		if err != nil {
+			return values, err
+		}
+		if kind == 'S' {
+			values[name] = string(data[4:n])
+		}
+		data = data[n:]
+	}
+	return values, nil
+}
+
+// amqpFieldSize 字段值占用的字节数
+func amqpFieldSize(kind byte, data []byte) (int, error) {
+	var n int
+	switch kind {
+	case 't', 'b', 'B':
+		n = 1
+	case 's', 'u':
+		n = 2
+	case 'I', 'i', 'f':
+		n = 4
+	case 'l', 'L', 'd', 'T':
+		n = 8
+	case 'D':
+		n = 5
+	case 'V':
+		n = 0
+	case 'S', 'F', 'A', 'x':
+		if len(data) < 4 {
+			return 0, errAMQPTable
+		}
+		n = 4 + int(binary.BigEndian.Uint32(data))
+	default:
+		return 0, errAMQPTable
+	}
+	if n > len(data) {
+		return 0, errAMQPTable
+	}
+	return n, nil
+}
+
+func init() {
+	mustRegisterDetector(amqpDetector{})
+}
@@ -0,0 +1,73 @@
+package gonmap
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Budget 结束扫描的超时预算
+type Budget string
+
+const (
+	BudgetPort    Budget = "port"
+	BudgetHost    Budget = "host"
+	BudgetContext Budget = "context"
+)
+
+// hostBudgets 记录每个主机的总预算截止时间 主机第一次被扫描时开始计时
+type hostBudgets struct {
+	mu        sync.Mutex
+	deadlines map[string]time.Time
+	sweepAt   time.Time
+}
+
+func newHostBudgets() *hostBudgets {
+	return &hostBudgets{deadlines: make(map[string]time.Time)}
+}
+
+// deadline 返回主机的截止时间 过期较久的记录会被清理 避免大规模扫描时无限增长
+func (h *hostBudgets) deadline(host string, budget time.Duration) time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	if now.After(h.sweepAt) {
+		for k, d := range h.deadlines {
+			if now.Sub(d) > budget {
+				delete(h.deadlines, k)
+			}
+		}
+		h.sweepAt = now.Add(budget)
+	}
+	d, ok := h.deadlines[host]
+	if !ok {
+		d = now.Add(budget)
+		h.deadlines[host] = d
+	}
+	return d
+}
+
+// scanDeadline 组合端口预算和主机预算 返回的 budget 函数用于判断哪个预算结束了扫描
+func (n *Nmap) scanDeadline(ctx context.Context, host string, timeouts Timeouts) (context.Context, context.CancelFunc, func() Budget) {
+	parent := ctx
+	deadline := time.Now().Add(timeouts.Port)
+	exhausted := BudgetPort
+	if timeouts.Host > 0 {
+		if hostDeadline := n.hosts.deadline(host, timeouts.Host); hostDeadline.Before(deadline) {
+			deadline = hostDeadline
+			exhausted = BudgetHost
+		}
+	}
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	budget := func() Budget {
+		if parent.Err() != nil {
+			return BudgetContext
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return exhausted
+		}
+		return ""
+	}
+	return ctx, cancel, budget
+}
@@ -34,6 +34,9 @@ func main() {
 		return
 	}
 	if options.UpdateRule {
+		if err := internal.UpdateRules(options); err != nil {
+			gologger.Error().Msgf(err.Error())
+		}
 		return
 	}
 	runner, err := internal.NewRunner(options)
@@ -41,6 +44,7 @@ func main() {
 		gologger.Error().Msgf(err.Error())
 		return
 	}
+	defer runner.Close()
 	fmt.Printf(Banner)
 	err = runner.Enumerate()
 	if err != nil {
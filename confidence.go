@@ -0,0 +1,89 @@
+package gonmap
+
+// softMatch 等待更具体匹配的 softmatch 结果
+type softMatch struct {
+	result   *MatchResult
+	expected bool
+	// 之后匹配到同一服务的探针数量
+	agree int
+}
+
+// matchConfidence 参考 nmap 的 conf 属性计算识别结果的可信度 范围 1-10
+// 硬匹配高于 softmatch 端口对应的探针 服务主动发送的 banner 以及 TLS 之上的匹配加分
+// 通过 fallback 或其他探针的指纹匹配减分 多个探针得到同一服务时加分
+func matchConfidence(result *MatchResult, expected, tls bool, agree int) int {
+	conf := 8
+	if result.Soft {
+		conf = 5
+	}
+	if expected {
+		conf++
+	}
+	if result.Probe == "NULL" && !result.Fallback {
+		conf++
+	}
+	if result.Fallback {
+		conf -= 2
+	}
+	if tls {
+		conf++
+	}
+	conf += min(agree, 2)
+	return max(1, min(conf, confidenceProbed))
+}
+
+// portHinted 探针是否声明了当前端口
+func portHinted(pb *probe, port int, ssl bool) bool {
+	if ssl {
+		return pb.sslports.exist(port)
+	}
+	return pb.ports.exist(port)
+}
+
+// sameService 只保留能识别该服务的探针
+func sameService(probes []*probe, service string) []*probe {
+	services := map[string]struct{}{service: {}}
+	var result []*probe
+	for _, pb := range probes {
+		if pb.identifies(services) {
+			result = append(result, pb)
+		}
+	}
+	return result
+}
+
+// ruleService 匹配规则中的服务名称 不包含 TLS 标记
+func (r *MatchResult) ruleService() string {
+	if r.match != nil {
+		return r.match.service
+	}
+	return plainService(r.Service)
+}
+
+// line 匹配规则在探针文件中的行号 检测器的结果为 0
+func (r *MatchResult) line() int {
+	if r.match != nil {
+		return r.match.line
+	}
+	return 0
+}
+
+func setMatched(response *Response, finger *MatchResult, expected, tls bool, agree int) {
+	response.Status = StatusMatched
+	response.Tls = tls
+	response.Service = finger
+	response.Confidence = matchConfidence(finger, expected, tls, agree)
+	response.setDetails(finger.Details)
+}
+
+// setDetails 将检测器的结构化信息放入 Response 对应的字段
+func (r *Response) setDetails(details any) {
+	switch d := details.(type) {
+	case *RDPInfo:
+		r.RDP = d
+	case *SMBInfo:
+		r.SMB = d
+	case map[string]any:
+		r.Details = d
+	}
+}
@@ -4,13 +4,13 @@ var PortRequest = map[int]string{
 	80:    "GetRequest",
 	110:   "NULL",
 	443:   "GetRequest",
-	445:   "SMBProgNeg",
+	445:   "SMB2Negotiate",
 	554:   "RTSPRequest",
 	25:    "NULL",
 	22:    "NULL",
 	587:   "NULL",
-	3389:  "TerminalServerCookie",
-	6379:  "GetRequest",
+	3389:  "RDPNegotiation",
+	6379:  "RedisInfo",
 	8008:  "GetRequest",
 	8080:  "GetRequest",
 	61616: "NULL",
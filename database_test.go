@@ -0,0 +1,240 @@
+package gonmap
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serveConn 启动本地服务 每个连接交给 handle 处理
+func serveConn(t *testing.T, handle func(conn net.Conn)) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+				handle(conn)
+			}()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func mysqlGreeting(version, plugin string) []byte {
+	payload := append([]byte{10}, version...)
+	payload = append(payload, 0)
+	payload = binary.LittleEndian.AppendUint32(payload, 42)
+	payload = append(payload, "abcdefgh"...)
+	payload = append(payload, 0)
+	// CLIENT_SSL CLIENT_PROTOCOL_41 以及 CLIENT_PLUGIN_AUTH
+	payload = binary.LittleEndian.AppendUint16(payload, 0xfa00|0x0800)
+	payload = append(payload, 0x21, 0x02, 0x00)
+	payload = binary.LittleEndian.AppendUint16(payload, 0x0008)
+	payload = append(payload, 21)
+	payload = append(payload, make([]byte, 10)...)
+	payload = append(payload, "ijklmnopqrst\x00"...)
+	payload = append(payload, plugin...)
+	payload = append(payload, 0)
+	header := []byte{byte(len(payload)), byte(len(payload) >> 8), 0, 0}
+	return append(header, payload...)
+}
+
+func bsonDocument(fields ...[]byte) []byte {
+	var body []byte
+	for _, field := range fields {
+		body = append(body, field...)
+	}
+	body = append(body, 0)
+	return append(binary.LittleEndian.AppendUint32(nil, uint32(len(body)+4)), body...)
+}
+
+func bsonString(name, value string) []byte {
+	field := append([]byte{0x02}, name...)
+	field = append(field, 0)
+	field = binary.LittleEndian.AppendUint32(field, uint32(len(value)+1))
+	return append(append(field, value...), 0)
+}
+
+func bsonField(kind byte, name string, value []byte) []byte {
+	field := append([]byte{kind}, name...)
+	return append(append(field, 0), value...)
+}
+
+func TestDatabaseDetectors(t *testing.T) {
+	n := New(&Options{VersionIntensity: 9, Timeout: 1})
+	scan := func(probe string, port int) *Response {
+		options := n.DefaultScanOptions()
+		options.Probes = []string{probe}
+		options.Timeouts.Read = time.Second
+		return n.ScanWithOptions(context.Background(), TCP, "127.0.0.1", port, options)
+	}
+
+	t.Run("mysql", func(t *testing.T) {
+		response := scan("MySQLHandshake", serveDetector(t, 0, mysqlGreeting("5.5.5-10.6.12-MariaDB-0ubuntu0.22.04.1", "mysql_native_password")))
+		if assert.Equal(t, StatusMatched, response.Status) {
+			assert.Equal(t, "MariaDB", response.Service.Product)
+			assert.Equal(t, "10.6.12", response.Service.Version)
+			assert.Equal(t, "mysql_native_password", response.Details["auth_plugin"])
+			assert.Equal(t, true, response.Details["ssl"])
+			assert.Equal(t, uint32(42), response.Details["thread_id"])
+		}
+		response = scan("MySQLHandshake", serveDetector(t, 0, mysqlGreeting("8.0.36", "caching_sha2_password")))
+		if assert.Equal(t, StatusMatched, response.Status) {
+			assert.Equal(t, "MySQL", response.Service.Product)
+			assert.Equal(t, "8.0.36", response.Service.Version)
+			assert.Equal(t, "caching_sha2_password", response.Details["auth_plugin"])
+		}
+		denied := append([]byte{0x45, 0, 0, 0, 0xff, 0x6a, 0x04}, "Host '10.0.0.1' is not allowed to connect to this MySQL server"...)
+		denied[0] = byte(len(denied) - 4)
+		response = scan("MySQLHandshake", serveDetector(t, 0, denied))
+		if assert.Equal(t, StatusMatched, response.Status) {
+			assert.Equal(t, "unauthorized", response.Service.Info)
+			assert.Equal(t, uint16(1130), response.Details["error_code"])
+		}
+	})
+
+	t.Run("postgresql", func(t *testing.T) {
+		port := serveConn(t, func(conn net.Conn) {
+			if _, err := io.ReadFull(conn, make([]byte, 8)); err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte("N"))
+			header := make([]byte, 4)
+			if _, err := io.ReadFull(conn, header); err != nil {
+				return
+			}
+			if _, err := io.ReadFull(conn, make([]byte, binary.BigEndian.Uint32(header)-4)); err != nil {
+				return
+			}
+			sasl := []byte("R\x00\x00\x00\x17\x00\x00\x00\x0aSCRAM-SHA-256\x00\x00")
+			_, _ = conn.Write(sasl)
+		})
+		response := scan("PostgreSQLStartup", port)
+		if assert.Equal(t, StatusMatched, response.Status) {
+			assert.Equal(t, "postgresql", response.Service.Service)
+			assert.Equal(t, "auth: sasl", response.Service.Info)
+			assert.Equal(t, false, response.Details["ssl"])
+			assert.Equal(t, []string{"SCRAM-SHA-256"}, response.Details["sasl_mechanisms"])
+		}
+		fields := postgresErrorFields([]byte("SFATAL\x00C28000\x00Mno pg_hba.conf entry\x00Fauth.c\x00L543\x00\x00"))
+		assert.Equal(t, "28000", fields['C'])
+		assert.Equal(t, "auth.c", fields['F'])
+	})
+
+	t.Run("mssql", func(t *testing.T) {
+		payload := []byte{
+			0x00, 0x00, 0x10, 0x00, 0x06,
+			0x01, 0x00, 0x16, 0x00, 0x01,
+			0x02, 0x00, 0x17, 0x00, 0x01,
+			0xff,
+			0x0f, 0x00, 0x07, 0xd0, 0x00, 0x00,
+			0x03,
+			0x00,
+		}
+		reply := append([]byte{0x04, 0x01, 0x00, byte(8 + len(payload)), 0x00, 0x00, 0x01, 0x00}, payload...)
+		response := scan("MSSQLPreLogin", serveDetector(t, len(mssqlPreLogin), reply))
+		if assert.Equal(t, StatusMatched, response.Status) {
+			assert.Equal(t, "mssql", response.Service.Service)
+			assert.Equal(t, "Microsoft SQL Server 2019", response.Service.Product)
+			assert.Equal(t, "15.00.2000.00", response.Service.Version)
+			assert.Equal(t, "required", response.Details["encryption"])
+			assert.Equal(t, "2019", response.Details["release"])
+		}
+	})
+
+	t.Run("redis", func(t *testing.T) {
+		info := "# Server\r\nredis_version:7.2.4\r\nredis_mode:standalone\r\nos:Linux 6.5.0 x86_64\r\narch_bits:64\r\n"
+		reply := []byte("$" + strconv.Itoa(len(info)) + "\r\n" + info + "\r\n")
+		response := scan("RedisInfo", serveDetector(t, 13, reply))
+		if assert.Equal(t, StatusMatched, response.Status) {
+			assert.Equal(t, "7.2.4", response.Service.Version)
+			assert.Equal(t, "Linux 6.5.0 x86_64", response.Service.OS)
+			assert.Equal(t, "standalone", response.Details["redis_mode"])
+			assert.Equal(t, false, response.Details["auth_required"])
+		}
+		response = scan("RedisInfo", serveDetector(t, 13, []byte("-NOAUTH Authentication required.\r\n")))
+		if assert.Equal(t, StatusMatched, response.Status) {
+			assert.Equal(t, "auth required", response.Service.Info)
+			assert.Equal(t, true, response.Details["auth_required"])
+		}
+	})
+
+	t.Run("mongodb", func(t *testing.T) {
+		helloDoc := func(wire byte) []byte {
+			return bsonDocument(
+				bsonField(0x08, "ismaster", []byte{1}),
+				bsonString("setName", "rs0"),
+				bsonField(0x04, "hosts", bsonDocument(bsonString("0", "db1:27017"), bsonString("1", "db2:27017"))),
+				bsonField(0x10, "maxWireVersion", []byte{wire, 0, 0, 0}),
+				bsonField(0x01, "ok", []byte{0, 0, 0, 0, 0, 0, 0xf0, 0x3f}),
+			)
+		}
+		// serveMongo 只有第一个消息可以使用 OP_QUERY 与 6.0 以上的服务端一致 legacy 时都可以使用 OP_QUERY
+		serveMongo := func(wire byte, version string, legacy bool) int {
+			build := bsonDocument(bsonString("version", version), bsonString("gitVersion", "7809d71e"))
+			return serveConn(t, func(conn net.Conn) {
+				for i, doc := range [][]byte{helloDoc(wire), build} {
+					header := make([]byte, 16)
+					if _, err := io.ReadFull(conn, header); err != nil {
+						return
+					}
+					if _, err := io.ReadFull(conn, make([]byte, binary.LittleEndian.Uint32(header)-16)); err != nil {
+						return
+					}
+					opCode := binary.LittleEndian.Uint32(header[12:])
+					var reply []byte
+					switch {
+					case opCode == mongoOpQuery && (i == 0 || legacy):
+						reply = binary.LittleEndian.AppendUint32(nil, uint32(36+len(doc)))
+						reply = binary.LittleEndian.AppendUint32(reply, 1)
+						reply = append(reply, header[4:8]...)
+						reply = binary.LittleEndian.AppendUint32(reply, mongoOpReply)
+						reply = append(reply, make([]byte, 16)...)
+						reply = binary.LittleEndian.AppendUint32(reply, 1)
+					case opCode == mongoOpMsg && !legacy:
+						reply = binary.LittleEndian.AppendUint32(nil, uint32(21+len(doc)))
+						reply = binary.LittleEndian.AppendUint32(reply, 1)
+						reply = append(reply, header[4:8]...)
+						reply = binary.LittleEndian.AppendUint32(reply, mongoOpMsg)
+						reply = append(reply, 0, 0, 0, 0, 0)
+					default:
+						// 6.0 以上的服务端对非握手的 OP_QUERY 直接断开连接
+						return
+					}
+					_, _ = conn.Write(append(reply, doc...))
+				}
+			})
+		}
+		response := scan("MongoDBHello", serveMongo(21, "7.0.5", false))
+		if assert.Equal(t, StatusMatched, response.Status) {
+			assert.NoError(t, response.Err)
+			assert.Equal(t, "MongoDB", response.Service.Product)
+			assert.Equal(t, "7.0.5", response.Service.Version)
+			assert.Equal(t, "replica set: rs0", response.Service.Info)
+			assert.Equal(t, "rs0", response.Details["set_name"])
+			assert.Equal(t, int32(21), response.Details["max_wire_version"])
+			assert.Equal(t, []any{"db1:27017", "db2:27017"}, response.Details["hosts"])
+			assert.Equal(t, "7809d71e", response.Details["git_version"])
+		}
+		// 3.4 及更早的服务端不支持 OP_MSG
+		response = scan("MongoDBHello", serveMongo(5, "3.4.24", true))
+		if assert.Equal(t, StatusMatched, response.Status) {
+			assert.Equal(t, "3.4.24", response.Service.Version)
+		}
+	})
+}
@@ -0,0 +1,182 @@
+package gonmap
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// Target 检测器的目标信息
+type Target struct {
+	Host     string
+	Port     int
+	Protocol Protocol
+	// 连接已经完成 TLS 握手
+	TLS bool
+	// TLS SNI 以及协议中的主机名 为空时为目标 IP
+	ServerName string
+	// Dial 建立到目标的新连接 TLS 与 conn 相同 用于需要多次连接的检测器
+	Dial func(ctx context.Context) (net.Conn, error)
+}
+
+// Detector 使用 Go 代码识别协议 用于多步握手 长度前缀的二进制协议等无法用单个负载和正则识别的场景
+// 检测器作为探针参与排序 强度和预算 名称与探针名称共用命名空间 可以在 ScanOptions.Probes 中引用
+type Detector interface {
+	// Name 检测器名称 格式与探针名称相同
+	Name() string
+	Protocol() Protocol
+	// Ports 优先使用该检测器的端口 与探针的 ports 指令相同
+	Ports() []int
+	// Services 可以识别的服务 用于验证模式
+	Services() []string
+	// Rarity 1-9 与探针的 rarity 指令相同
+	Rarity() int
+	// Detect 在已经建立的连接上识别服务 没有识别结果时返回 nil
+	// conn 的读写期限和 ctx 的期限为单个探针的等待时间
+	Detect(ctx context.Context, conn net.Conn, target Target) (*MatchResult, error)
+}
+
+// SSLPortsDetector 检测器可选实现 对应探针的 sslports 指令
+type SSLPortsDetector interface {
+	SSLPorts() []int
+}
+
+var detectorRegistry struct {
+	sync.Mutex
+	detectors []Detector
+}
+
+// RegisterDetector 注册检测器 之后创建或 Reload 的 Nmap 会加载该检测器
+func RegisterDetector(d Detector) error {
+	if !probeNameRegx.MatchString(d.Name()) {
+		return fmt.Errorf("detector 名称不正确: %s", d.Name())
+	}
+	if d.Protocol() != TCP && d.Protocol() != UDP {
+		return fmt.Errorf("detector %s 协议不正确: %s", d.Name(), d.Protocol())
+	}
+	if d.Rarity() < 1 || d.Rarity() > 9 {
+		return fmt.Errorf("rarity 必须在 1-9 之间: %d", d.Rarity())
+	}
+	detectorRegistry.Lock()
+	defer detectorRegistry.Unlock()
+	for _, exist := range detectorRegistry.detectors {
+		if exist.Name() == d.Name() && exist.Protocol() == d.Protocol() {
+			return fmt.Errorf("detector %s %s already exists", d.Protocol(), d.Name())
+		}
+	}
+	detectorRegistry.detectors = append(detectorRegistry.detectors, d)
+	return nil
+}
+
+// Detectors 返回已注册的检测器 按名称排序
+func Detectors() []Detector {
+	detectorRegistry.Lock()
+	defer detectorRegistry.Unlock()
+	detectors := append([]Detector(nil), detectorRegistry.detectors...)
+	sort.SliceStable(detectors, func(i, j int) bool {
+		return detectors[i].Name() < detectors[j].Name()
+	})
+	return detectors
+}
+
+func mustRegisterDetector(d Detector) {
+	if err := RegisterDetector(d); err != nil {
+		panic(err)
+	}
+}
+
+// detectorProbe 将检测器包装为探针 参与排序和强度过滤
+func detectorProbe(d Detector) *probe {
+	p := newProbe()
+	p.Name = d.Name()
+	p.protocol = d.Protocol()
+	p.ports = append(PortList(nil), d.Ports()...).removeDuplicate()
+	if s, ok := d.(SSLPortsDetector); ok {
+		p.sslports = append(PortList(nil), s.SSLPorts()...).removeDuplicate()
+	}
+	p.rarity, p.hasRarity = d.Rarity(), true
+	for _, service := range d.Services() {
+		p.services[FixProtocol(service)] = struct{}{}
+	}
+	p.detector = d
+	return p
+}
+
+// addDetectors 将检测器加入探针库 与已有探针重名时跳过
+func (db *probeDB) addDetectors(detectors []Detector) {
+	for _, d := range detectors {
+		p := detectorProbe(d)
+		exists := false
+		for _, exist := range db.probes(p.protocol) {
+			if exist.Name == p.Name {
+				exists = true
+				break
+			}
+		}
+		if exists {
+			db.warnings = append(db.warnings, fmt.Sprintf("detector %s skipped: probe with the same name exists", p.Name))
+			continue
+		}
+		if p.protocol == TCP {
+			db.tcpProbes = append(db.tcpProbes, p)
+		} else {
+			db.udpProbes = append(db.udpProbes, p)
+		}
+	}
+}
+
+// runDetector 建立连接后调用检测器 连接失败时返回 StatusPortClose 与发送探针一致
+func runDetector(ctx context.Context, dialer proxy.Dialer, pb *probe, target Target, connectTimeout, wait time.Duration) (*MatchResult, PortStatus, error) {
+	address := net.JoinHostPort(target.Host, fmt.Sprint(target.Port))
+	var tlsErr error
+	target.Dial = func(ctx context.Context) (net.Conn, error) {
+		dialCtx, cancel := context.WithTimeout(ctx, connectTimeout)
+		defer cancel()
+		var conn net.Conn
+		var err error
+		if target.Protocol == UDP {
+			conn, err = (&net.Dialer{}).DialContext(dialCtx, "udp", address)
+		} else {
+			conn, err = dialContext(dialCtx, dialer, "tcp", address)
+		}
+		if err != nil || !target.TLS {
+			return conn, err
+		}
+		tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true, ServerName: target.ServerName})
+		if err := tlsConn.HandshakeContext(dialCtx); err != nil {
+			_ = conn.Close()
+			tlsErr = err
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+	conn, err := target.Dial(ctx)
+	if err != nil {
+		if tlsErr != nil {
+			return nil, StatusTlsError, err
+		}
+		return nil, StatusPortClose, err
+	}
+	defer conn.Close()
+	detectCtx, cancelDetect := context.WithTimeout(ctx, wait)
+	defer cancelDetect()
+	if deadline, ok := detectCtx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(detectCtx, func() {
+		_ = conn.SetDeadline(time.Now())
+	})
+	defer stop()
+	result, err := pb.detector.Detect(detectCtx, conn, target)
+	if result != nil {
+		result.Probe = pb.Name
+		result.Service = FixProtocol(result.Service)
+	}
+	return result, StatusPortOpen, err
+}
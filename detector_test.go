@@ -0,0 +1,111 @@
+package gonmap
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serveDetector 启动本地服务 读取 request 长度的请求后写入 reply
+func serveDetector(t *testing.T, request int, reply []byte) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+				if _, err := io.ReadFull(conn, make([]byte, request)); err == nil {
+					_, _ = conn.Write(reply)
+				}
+			}()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func amqpStartFrame(properties map[string]string) []byte {
+	var table []byte
+	for name, value := range properties {
+		table = append(table, byte(len(name)))
+		table = append(table, name...)
+		table = append(table, 'S')
+		table = binary.BigEndian.AppendUint32(table, uint32(len(value)))
+		table = append(table, value...)
+	}
+	// capabilities 嵌套表
+	table = append(table, 12)
+	table = append(table, "capabilities"...)
+	table = append(table, 'F', 0, 0, 0, 6, 3, 'a', 'c', 'k', 't', 1)
+	payload := []byte{0, 10, 0, 10, 0, 9}
+	payload = binary.BigEndian.AppendUint32(payload, uint32(len(table)))
+	payload = append(payload, table...)
+	frame := []byte{1, 0, 0}
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(payload)))
+	frame = append(frame, payload...)
+	return append(frame, 0xce)
+}
+
+func TestDetectors(t *testing.T) {
+	// 检测器在未声明的端口上只在强度 8 以上发送
+	n := New(&Options{VersionIntensity: 9, Timeout: 1})
+	probe, ok := n.FindProbe(TCP, "MQTTConnect")
+	if assert.True(t, ok) {
+		assert.True(t, probe.IsDetector())
+		assert.Equal(t, []int{1883}, probe.Ports())
+	}
+	assert.Error(t, RegisterDetector(mqttDetector{}))
+
+	options := n.DefaultScanOptions()
+	options.Timeouts.Read = time.Second
+	options.Probes = []string{"MQTTConnect"}
+	port := serveDetector(t, len(mqttConnect), []byte{0x20, 0x02, 0x00, 0x05})
+	response := n.ScanWithOptions(context.Background(), TCP, "127.0.0.1", port, options)
+	if assert.Equal(t, StatusMatched, response.Status) {
+		assert.Equal(t, "mqtt", response.Service.Service)
+		assert.Equal(t, "auth required", response.Service.Info)
+		assert.Equal(t, "MQTTConnect", response.Service.Probe)
+	}
+
+	options.Probes = []string{"AMQPConnectionStart"}
+	port = serveDetector(t, len(amqpHeader), amqpStartFrame(map[string]string{"product": "RabbitMQ", "version": "3.12.1", "platform": "Erlang/OTP 26.0"}))
+	response = n.ScanWithOptions(context.Background(), TCP, "127.0.0.1", port, options)
+	if assert.Equal(t, StatusMatched, response.Status) {
+		assert.Equal(t, "amqp", response.Service.Service)
+		assert.Equal(t, "RabbitMQ", response.Service.Product)
+		assert.Equal(t, "3.12.1", response.Service.Version)
+		assert.Equal(t, "Erlang/OTP 26.0", response.Service.Info)
+	}
+
+	// 验证模式按检测器声明的服务选择
+	response = n.Verify(context.Background(), TCP, "127.0.0.1", port, []string{"amqp"}, nil)
+	assert.Equal(t, VerdictConfirmed, response.Verdict)
+
+	// 默认强度下检测器只发送给声明的端口
+	n = New(&Options{VersionIntensity: 7, Timeout: 1})
+	cfg := n.defaultScanConfig()
+	for _, pb := range cfg.tcpProbes(n.probeDB(), 12345, false) {
+		assert.False(t, pb.detector != nil, pb.Name)
+	}
+	var names []string
+	for _, pb := range cfg.tcpProbes(n.probeDB(), 1883, false) {
+		names = append(names, pb.Name)
+	}
+	assert.Contains(t, names, "MQTTConnect")
+
+	n = New(&Options{VersionIntensity: 7, Timeout: 1, DisableDetectors: true})
+	_, ok = n.FindProbe(TCP, "MQTTConnect")
+	assert.False(t, ok)
+}
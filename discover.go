@@ -0,0 +1,43 @@
+package gonmap
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// ConnectScan 使用 TCP connect 判断端口状态 只建立连接不发送任何数据
+// 返回的 Response.Status 为 open close filtered 或连接错误 Response.Reason 为判断依据
+func (n *Nmap) ConnectScan(ctx context.Context, ip string, port int, timeout time.Duration) *Response {
+	address := net.JoinHostPort(ip, fmt.Sprint(port))
+	response := &Response{Status: StatusUnknown, Address: address, Protocol: TCP}
+	dialer, err := NewDialer(n.option.Proxy, timeout)
+	if err != nil {
+		n.option.logger().Errorf("Failed to create dialer: %s", err)
+		response.setError(wrapError(ErrDialer, err))
+		return response
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	conn, err := dialContext(ctx, dialer, "tcp", address)
+	if err != nil {
+		n.option.logger().Debugf("Connect %s error: %v", address, err)
+		response.Status, response.Reason = classifyDialError(err, n.option.Proxy != "")
+		response.setError(dialError(response.Status, err))
+		return response
+	}
+	_ = conn.Close()
+	response.Status = StatusOpen
+	response.Reason = ReasonSynAck
+	return response
+}
+
+func dialContext(ctx context.Context, dialer proxy.Dialer, network, address string) (net.Conn, error) {
+	if d, ok := dialer.(proxy.ContextDialer); ok {
+		return d.DialContext(ctx, network, address)
+	}
+	return dialer.Dial(network, address)
+}
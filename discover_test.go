@@ -0,0 +1,125 @@
+package gonmap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnectScan(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	openPort := listener.Addr().(*net.TCPAddr).Port
+	defer listener.Close()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	_ = closed.Close()
+
+	n := New(&Options{VersionIntensity: 7, Timeout: 1})
+	ctx := context.Background()
+	assert.Equal(t, StatusOpen, n.ConnectScan(ctx, "127.0.0.1", openPort, time.Second).Status)
+	response := n.ConnectScan(ctx, "127.0.0.1", closedPort, time.Second)
+	assert.Equal(t, StatusClose, response.Status)
+	assert.Equal(t, ReasonConnRefused, response.Reason)
+	// 服务识别在第一次连接被拒绝时即判断为关闭
+	response = n.ScanWithOptions(ctx, TCP, "127.0.0.1", closedPort, nil)
+	assert.Equal(t, StatusClose, response.Status)
+	assert.Equal(t, ReasonConnRefused, response.Reason)
+}
+
+func TestScanIPv6(t *testing.T) {
+	listener, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("ipv6 loopback unavailable: %s", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte("SSH-2.0-OpenSSH_8.0\r\n"))
+			_ = conn.Close()
+		}
+	}()
+	port := listener.Addr().(*net.TCPAddr).Port
+	address := fmt.Sprintf("[::1]:%d", port)
+
+	n := New(&Options{VersionIntensity: 7, Timeout: 1})
+	ctx := context.Background()
+	response := n.ConnectScan(ctx, "::1", port, time.Second)
+	assert.Equal(t, StatusOpen, response.Status)
+	assert.Equal(t, address, response.Address)
+	options := n.DefaultScanOptions()
+	options.Probes = []string{"NULL"}
+	response = n.ScanWithOptions(ctx, TCP, "::1", port, options)
+	assert.Equal(t, StatusMatched, response.Status)
+	assert.Equal(t, address, response.Address)
+	assert.Equal(t, "ssh", response.Service.Service)
+	// 探针中的 {Host} 替换为带方括号的地址
+	assert.Equal(t, address, n.defaultScanConfig().hostValue("::1", port))
+}
+
+func TestClassifyDialError(t *testing.T) {
+	dialErr := func(err error) error {
+		return &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", err)}
+	}
+	cases := []struct {
+		err      error
+		viaProxy bool
+		status   Status
+		reason   string
+	}{
+		{dialErr(syscall.ECONNREFUSED), false, StatusClose, ReasonConnRefused},
+		{dialErr(syscall.EHOSTUNREACH), false, StatusFiltered, ReasonHostUnreach},
+		{dialErr(syscall.EMFILE), false, StatusResourceError, ReasonResourceError},
+		{context.DeadlineExceeded, false, StatusFiltered, ReasonNoResponse},
+		// 经过代理时连接被拒绝的是代理本身
+		{dialErr(syscall.ECONNREFUSED), true, StatusProxyError, ReasonProxyError},
+		{&net.OpError{Op: "socks connect", Net: "tcp", Err: errors.New("unknown error connection refused")}, true, StatusClose, ReasonConnRefused},
+	}
+	for _, c := range cases {
+		status, reason := classifyDialError(c.err, c.viaProxy)
+		assert.Equal(t, c.status, status, c.err.Error())
+		assert.Equal(t, c.reason, reason, c.err.Error())
+	}
+}
+
+func TestResponseError(t *testing.T) {
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	_ = closed.Close()
+	ctx := context.Background()
+
+	n := New(&Options{VersionIntensity: 7, Timeout: 1, Proxy: fmt.Sprintf("socks5://127.0.0.1:%d", closedPort)})
+	response := n.ConnectScan(ctx, "127.0.0.1", 80, time.Second)
+	assert.Equal(t, StatusProxyError, response.Status)
+	assert.ErrorIs(t, response.Err, ErrProxy)
+	assert.NotEmpty(t, response.Error)
+
+	n = New(&Options{VersionIntensity: 7, Timeout: 1, Proxy: "://bad"})
+	assert.ErrorIs(t, n.ScanWithOptions(ctx, TCP, "127.0.0.1", 80, nil).Err, ErrDialer)
+
+	n = New(&Options{VersionIntensity: 7, Timeout: 1})
+	assert.ErrorIs(t, n.ScanWithOptions(ctx, TCP, "127.0.0.1", 9100, nil).Err, ErrExcludedPort)
+	assert.ErrorIs(t, n.ScanWithOptions(ctx, TCP, "127.0.0.1", 0, nil).Err, ErrInvalidTarget)
+	_, err = n.ScanAddress(TCP, "127.0.0.1")
+	assert.ErrorIs(t, err, ErrInvalidTarget)
+	assert.Nil(t, n.ScanWithOptions(ctx, TCP, "127.0.0.1", closedPort, nil).Err)
+}
@@ -0,0 +1,41 @@
+package gonmap
+
+import (
+	"errors"
+	"fmt"
+)
+
+// 扫描过程中的错误 通过 errors.Is 判断 Response.Err 以及各个接口返回的错误都包装了这些错误
+var (
+	// ErrInvalidTarget 目标地址或端口无法解析
+	ErrInvalidTarget = errors.New("invalid target")
+	// ErrEmptyTarget 目标为空 同时匹配 ErrInvalidTarget
+	ErrEmptyTarget = fmt.Errorf("%w: empty target", ErrInvalidTarget)
+	// ErrDialer 无法创建连接器 例如代理地址格式错误
+	ErrDialer = errors.New("create dialer failed")
+	// ErrProxy 代理连接失败或代理返回错误
+	ErrProxy = errors.New("proxy error")
+	// ErrTimeoutBudget 端口或主机的超时预算耗尽
+	ErrTimeoutBudget = errors.New("timeout budget exhausted")
+	// ErrExcludedPort 端口在探针文件的 Exclude 指令中
+	ErrExcludedPort = errors.New("port excluded by probe file")
+	// ErrProbeDB 探针库加载失败
+	ErrProbeDB = errors.New("load probe database failed")
+)
+
+// wrapError 使用哨兵错误包装底层错误 底层错误同样可以通过 errors.Is/As 判断
+func wrapError(sentinel, err error) error {
+	if err == nil {
+		return sentinel
+	}
+	return fmt.Errorf("%w: %w", sentinel, err)
+}
+
+// setError 记录扫描中最有意义的错误 JSON 中输出错误信息
+func (r *Response) setError(err error) {
+	r.Err = err
+	r.Error = ""
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
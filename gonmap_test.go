@@ -75,4 +75,111 @@ func TestProbeSort(t *testing.T) {
 	assert.True(t, probeList[0].Name == "SSLSessionReq")
 	assert.True(t, probeList[1].Name == "TLSSessionReq")
 
+	// 端口匹配的检测器在文件探针之前 其余顺序不变
+	n := New(&Options{VersionIntensity: 7})
+	probeList = sortProbes(n.probeDB().tcpProbes, 1883, false)
+	assert.Equal(t, "MQTTConnect", probeList[0].Name)
+	var hinted []string
+	for _, pb := range probeList[1:] {
+		if pb.ports.exist(1883) {
+			hinted = append(hinted, pb.Name)
+		}
+	}
+	assert.Contains(t, hinted, "mqtt")
+	probeList = sortProbes(n.probeDB().tcpProbes, 443, true)
+	assert.Equal(t, "GetRequest", probeList[0].Name)
+}
+
+func TestVersionInfo(t *testing.T) {
+	probeList := LoadProbes(probes, 9)
+	result := matchAll(probeList, []byte("SSH-2.0-VShell_4_0_3 VShell\r\n"))
+	if assert.NotNil(t, result) {
+		assert.Equal(t, "VanDyke VShell sshd", result.Product)
+		assert.Equal(t, "4.0.3", result.Version)
+		assert.Equal(t, "protocol 2.0", result.Info)
+		assert.Equal(t, []string{"cpe:/a:vandyke:vshell:4.0.3"}, result.CPE)
+	}
+	m, err := parseMatch(`test m=^\xff\xfe(\w+)\|=si p|Test $P(1)| h/$1/ o|Linux| cpe:/o:linux:linux_kernel/a`, false)
+	assert.NoError(t, err)
+	p := &probe{matchGroup: []*match{m}}
+	result = p.match([]byte("\xff\xfeHOST|"))
+	if assert.NotNil(t, result) {
+		assert.Equal(t, "Test HOST", result.Product)
+		assert.Equal(t, "HOST", result.Hostname)
+		assert.Equal(t, "Linux", result.OS)
+		assert.Equal(t, []string{"cpe:/o:linux:linux_kernel"}, result.CPE)
+	}
+}
+
+func TestProbeGrammar(t *testing.T) {
+	file, err := parseProbeFile(`Exclude T:9100-9101,U:53
+Probe UDP Sqlping q|\x02| no-payload
+ports 1434
+sslports T:443,U:1434-1435
+totalwaitms 6000
+tcpwrappedms 3000
+unknowndirective foo
+match ms-sql-m m|^\x05|
+`)
+	assert.NoError(t, err)
+	assert.Equal(t, PortList{9100, 9101}, file.exclude[TCP])
+	assert.Equal(t, PortList{53}, file.exclude[UDP])
+	assert.Len(t, file.warnings, 1)
+	pb := file.probes[0]
+	assert.True(t, pb.noPayload)
+	assert.Equal(t, "\x02", pb.sendRaw)
+	assert.Equal(t, PortList{1434, 1435}, pb.sslports)
+	assert.Equal(t, int64(6000), pb.totalWaiTms.Milliseconds())
+	assert.Equal(t, int64(3000), pb.tcpwrappedms.Milliseconds())
+
+	_, err = parseProbeFile("Probe TCP Bad q|unterminated\n")
+	assert.Error(t, err)
+}
+
+func TestSelectProbes(t *testing.T) {
+	probeList := LoadProbes(probes, 9)
+	selected := selectProbes(probeList, 3389, false, 0)
+	var names []string
+	for _, pb := range selected {
+		names = append(names, pb.Name)
+		assert.True(t, pb.isNullProbe() || pb.ports.exist(3389))
+	}
+	assert.Contains(t, names, "NULL")
+	assert.Contains(t, names, "TerminalServerCookie")
+	assert.Equal(t, len(probeList), len(selectProbes(probeList, 3389, false, 9)))
+	// 最后一个探针同样按 rarity 过滤
+	last := probeList[len(probeList)-1]
+	assert.NotContains(t, LoadProbes(probes, last.effectiveRarity()-1), last)
+}
+
+func TestMatchConfidence(t *testing.T) {
+	file, err := parseProbeFile(`
+Probe TCP NULL q||
+softmatch ftp m|^220 |
+match ssh m|^SSH-2\.0-OpenSSH_([\w.]+)| p/OpenSSH/ v/$1/
+
+Probe TCP GenericLines q|\r\n\r\n|
+ports 21
+fallback NULL
+match ftp m|^220 FileZilla| p/FileZilla/
+`)
+	assert.NoError(t, err)
+	db := newProbeDB(file.probes)
+	null, lines := db.tcpProbes[0], db.tcpProbes[1]
+
+	hard := null.match([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+	assert.Equal(t, 9, matchConfidence(hard, false, false, 0))
+	soft := null.match([]byte("220 ready\r\n"))
+	assert.True(t, soft.Soft)
+	assert.Equal(t, 6, matchConfidence(soft, false, false, 0))
+
+	fallback := lines.match([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+	if assert.NotNil(t, fallback) {
+		assert.True(t, fallback.Fallback)
+		assert.Equal(t, "GenericLines", fallback.Probe)
+		assert.Less(t, matchConfidence(fallback, false, false, 0), matchConfidence(hard, false, false, 0))
+	}
+	filezilla := lines.match([]byte("220 FileZilla Server\r\n"))
+	assert.Equal(t, 10, matchConfidence(filezilla, true, false, 1))
+	assert.Equal(t, []*probe{lines}, sameService(db.tcpProbes, "ftp")[1:])
 }
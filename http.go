@@ -0,0 +1,270 @@
+package gonmap
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	defaultHTTPBodySize    = 1 << 20
+	defaultHTTPRedirects   = 3
+	httpUserAgent          = "Mozilla/5.0 (compatible; gonmap)"
+	defaultFaviconLocation = "/favicon.ico"
+)
+
+// HTTPOptions 识别到 http/https 服务后获取页面信息的参数
+type HTTPOptions struct {
+	// 最多跟随的重定向次数 只跟随同一主机的重定向 为 0 时使用默认值 小于 0 时不跟随
+	MaxRedirects int
+	// 不请求 favicon
+	DisableIcon bool
+	// 读取响应正文的最大字节数 为 0 时使用默认值
+	MaxBodySize int
+	// 识别 Web 应用 结果记录在 Response.Apps 为空时不识别 可以使用 LoadWebRules 加载规则目录
+	Fingerprinter WebFingerprinter
+}
+
+// HTTPInfo 对 http/https 服务发送 GET 请求得到的页面信息
+type HTTPInfo struct {
+	// 跟随重定向后的地址
+	URL           string `json:"url"`
+	StatusCode    int    `json:"status_code"`
+	Title         string `json:"title,omitempty"`
+	Server        string `json:"server,omitempty"`
+	PoweredBy     string `json:"powered_by,omitempty"`
+	ContentLength int64  `json:"content_length"`
+	FaviconURL    string `json:"favicon_url,omitempty"`
+	FaviconMD5    string `json:"favicon_md5,omitempty"`
+	// 与 Shodan http.favicon.hash 相同的 mmh3 哈希
+	FaviconMMH3 int32 `json:"favicon_mmh3,omitempty"`
+	// 响应头和正文 正文按 MaxBodySize 截断
+	Header http.Header `json:"-"`
+	Body   []byte      `json:"-"`
+}
+
+var (
+	titleRegx   = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	iconRegx    = regexp.MustCompile(`(?is)<link[^>]*\srel\s*=\s*["']?[^"'>]*icon[^>]*>`)
+	hrefRegx    = regexp.MustCompile(`(?is)\shref\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+	spaceRegexp = regexp.MustCompile(`\s+`)
+)
+
+// isHTTPService 服务是否为 http 或 https
+func isHTTPService(service string) bool {
+	return plainService(service) == "http"
+}
+
+// enrichHTTP 对识别为 http/https 的端口发送 GET 请求 记录页面信息
+// 请求始终发往扫描的 IP Host 和 SNI 使用 ScanOptions.ServerName
+func (n *Nmap) enrichHTTP(ctx context.Context, response *Response, ip string, port int, cfg *scanConfig) {
+	dialer, err := NewDialer(n.option.Proxy, cfg.timeouts.Connect)
+	if err != nil {
+		cfg.logger.Debugf("Failed to create dialer: %s", err)
+		return
+	}
+	maxRedirects := cfg.http.MaxRedirects
+	if maxRedirects == 0 {
+		maxRedirects = defaultHTTPRedirects
+	}
+	bodySize := cfg.http.MaxBodySize
+	if bodySize <= 0 {
+		bodySize = defaultHTTPBodySize
+	}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			_, p, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			return dialContext(ctx, dialer, network, net.JoinHostPort(ip, p))
+		},
+		TLSClientConfig:       &tls.Config{InsecureSkipVerify: true, ServerName: cfg.serverName},
+		TLSHandshakeTimeout:   cfg.timeouts.Connect,
+		ResponseHeaderTimeout: cfg.timeouts.Read,
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			// 连接固定发往扫描的 IP 其他主机的重定向不跟随
+			if len(via) > maxRedirects || req.URL.Hostname() != via[0].URL.Hostname() {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+	}
+	scheme := "http"
+	if response.Tls || response.Service.Service != "http" {
+		scheme = "https"
+	}
+	target := scheme + "://" + cfg.hostValue(ip, port) + "/"
+	info, err := fetchHTTP(ctx, client, target, bodySize)
+	if err != nil {
+		cfg.logger.Debugf("HTTP request %s failed: %s", target, err)
+		return
+	}
+	if !cfg.http.DisableIcon {
+		fetchFavicon(ctx, client, info)
+	}
+	response.HTTP = info
+	if cfg.http.Fingerprinter != nil {
+		response.Apps = cfg.http.Fingerprinter.Fingerprint(ctx, info, sameHostFetch(client, info.URL, bodySize))
+	}
+}
+
+// sameHostFetch 只允许请求与页面同一主机的地址 连接固定发往扫描的 IP
+func sameHostFetch(client *http.Client, page string, bodySize int) FetchFunc {
+	return func(ctx context.Context, location string) ([]byte, error) {
+		base, err := url.Parse(page)
+		if err != nil {
+			return nil, err
+		}
+		target, err := base.Parse(location)
+		if err != nil {
+			return nil, err
+		}
+		if target.Hostname() != base.Hostname() {
+			return nil, fmt.Errorf("%s is not on host %s", location, base.Hostname())
+		}
+		resp, body, err := httpGet(ctx, client, target.String(), bodySize)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s: %s", location, resp.Status)
+		}
+		return body, nil
+	}
+}
+
+// fetchHTTP 发送 GET 请求并解析页面信息
+func fetchHTTP(ctx context.Context, client *http.Client, target string, bodySize int) (*HTTPInfo, error) {
+	resp, body, err := httpGet(ctx, client, target, bodySize)
+	if err != nil {
+		return nil, err
+	}
+	info := &HTTPInfo{
+		URL:           resp.Request.URL.String(),
+		StatusCode:    resp.StatusCode,
+		Server:        resp.Header.Get("Server"),
+		PoweredBy:     resp.Header.Get("X-Powered-By"),
+		ContentLength: resp.ContentLength,
+		Header:        resp.Header,
+		Body:          body,
+	}
+	if info.ContentLength < 0 {
+		info.ContentLength = int64(len(body))
+	}
+	if m := titleRegx.FindSubmatch(body); m != nil {
+		info.Title = strings.TrimSpace(spaceRegexp.ReplaceAllString(html.UnescapeString(string(m[1])), " "))
+	}
+	return info, nil
+}
+
+// fetchFavicon 请求页面声明的图标 没有声明时请求 /favicon.ico
+func fetchFavicon(ctx context.Context, client *http.Client, info *HTTPInfo) {
+	base, err := url.Parse(info.URL)
+	if err != nil {
+		return
+	}
+	location := defaultFaviconLocation
+	if link := iconRegx.Find(info.Body); link != nil {
+		if m := hrefRegx.FindSubmatch(link); m != nil {
+			location = html.UnescapeString(string(m[1]) + string(m[2]) + string(m[3]))
+		}
+	}
+	icon, err := base.Parse(location)
+	if err != nil || (icon.Scheme != "http" && icon.Scheme != "https") || icon.Hostname() != base.Hostname() {
+		return
+	}
+	resp, data, err := httpGet(ctx, client, icon.String(), defaultHTTPBodySize)
+	if err != nil || resp.StatusCode != http.StatusOK || len(data) == 0 {
+		return
+	}
+	sum := md5.Sum(data)
+	info.FaviconURL = icon.String()
+	info.FaviconMD5 = hex.EncodeToString(sum[:])
+	info.FaviconMMH3 = faviconHash(data)
+}
+
+func httpGet(ctx context.Context, client *http.Client, target string, bodySize int) (*http.Response, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("User-Agent", httpUserAgent)
+	req.Header.Set("Accept", "*/*")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, int64(bodySize)))
+	if err != nil && len(body) == 0 {
+		return nil, nil, fmt.Errorf("read body: %w", err)
+	}
+	return resp, body, nil
+}
+
+// faviconHash 与 Shodan 相同 对每 76 个字符换行的 base64 编码计算 mmh3
+func faviconHash(data []byte) int32 {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	var b strings.Builder
+	for len(encoded) > 76 {
+		b.WriteString(encoded[:76])
+		b.WriteByte('\n')
+		encoded = encoded[76:]
+	}
+	b.WriteString(encoded)
+	b.WriteByte('\n')
+	return int32(murmur3([]byte(b.String()), 0))
+}
+
+// murmur3 MurmurHash3 x86 32 位
+func murmur3(data []byte, seed uint32) uint32 {
+	const c1, c2 = 0xcc9e2d51, 0x1b873593
+	h := seed
+	length := len(data)
+	for ; len(data) >= 4; data = data[4:] {
+		k := uint32(data[0]) | uint32(data[1])<<8 | uint32(data[2])<<16 | uint32(data[3])<<24
+		k *= c1
+		k = k<<15 | k>>17
+		k *= c2
+		h ^= k
+		h = h<<13 | h>>19
+		h = h*5 + 0xe6546b64
+	}
+	var k uint32
+	switch len(data) {
+	case 3:
+		k ^= uint32(data[2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(data[1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(data[0])
+		k *= c1
+		k = k<<15 | k>>17
+		k *= c2
+		h ^= k
+	}
+	h ^= uint32(length)
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
@@ -0,0 +1,78 @@
+package gonmap
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMurmur3(t *testing.T) {
+	assert.Equal(t, uint32(0), murmur3(nil, 0))
+	assert.Equal(t, uint32(0x248bfa47), murmur3([]byte("hello"), 0))
+	assert.Equal(t, uint32(0x2e4ff723), murmur3([]byte("The quick brown fox jumps over the lazy dog"), 0))
+}
+
+func TestEnrichHTTP(t *testing.T) {
+	icon := []byte("\x00\x00\x01\x00fake icon")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+		http.NotFound(w, r)
+	})
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Powered-By", "PHP/8.2.1")
+		_, _ = w.Write([]byte(`<html><head><title> Sign in &amp;
+ Manage </title><link rel="shortcut icon" href="/static/logo.ico"></head></html>`))
+	})
+	mux.HandleFunc("/static/logo.ico", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(icon)
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "nginx/1.25.3")
+		mux.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	_, portValue, _ := net.SplitHostPort(server.Listener.Addr().String())
+	port, _ := strconv.Atoi(portValue)
+
+	n := New(&Options{VersionIntensity: 7, Timeout: 1})
+	options := n.DefaultScanOptions()
+	options.Probes = []string{"GetRequest"}
+	options.Timeouts.Read = time.Second
+	options.HTTP = &HTTPOptions{}
+	response := n.ScanWithOptions(context.Background(), TCP, "127.0.0.1", port, options)
+	if assert.Equal(t, StatusMatched, response.Status) && assert.NotNil(t, response.HTTP) {
+		info := response.HTTP
+		assert.Equal(t, server.URL+"/login", info.URL)
+		assert.Equal(t, http.StatusOK, info.StatusCode)
+		assert.Equal(t, "Sign in & Manage", info.Title)
+		assert.Equal(t, "nginx/1.25.3", info.Server)
+		assert.Equal(t, "PHP/8.2.1", info.PoweredBy)
+		sum := md5.Sum(icon)
+		assert.Equal(t, hex.EncodeToString(sum[:]), info.FaviconMD5)
+		assert.Equal(t, faviconHash(icon), info.FaviconMMH3)
+		assert.Equal(t, server.URL+"/static/logo.ico", info.FaviconURL)
+	}
+
+	// 不跟随重定向 不请求图标
+	options.HTTP = &HTTPOptions{MaxRedirects: -1, DisableIcon: true}
+	response = n.ScanWithOptions(context.Background(), TCP, "127.0.0.1", port, options)
+	if assert.NotNil(t, response.HTTP) {
+		assert.Equal(t, http.StatusFound, response.HTTP.StatusCode)
+		assert.Empty(t, response.HTTP.FaviconMD5)
+	}
+
+	options.HTTP = nil
+	assert.Nil(t, n.ScanWithOptions(context.Background(), TCP, "127.0.0.1", port, options).HTTP)
+}
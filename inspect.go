@@ -0,0 +1,216 @@
+package gonmap
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Probe 探针的只读视图 由 Nmap.Probes 等查询接口返回
+// 视图引用加载完成的探针库 返回的切片都是副本 修改不会影响探针库
+type Probe struct {
+	p *probe
+}
+
+func (p Probe) Name() string {
+	return p.p.Name
+}
+
+func (p Probe) Protocol() Protocol {
+	return p.p.protocol
+}
+
+// Payload 探针发送的数据 {Host} 在发送时替换为目标地址
+func (p Probe) Payload() []byte {
+	return []byte(p.p.sendRaw)
+}
+
+func (p Probe) Ports() []int {
+	return append([]int(nil), p.p.ports...)
+}
+
+func (p Probe) SSLPorts() []int {
+	return append([]int(nil), p.p.sslports...)
+}
+
+// Rarity 生效的 rarity 未声明时为默认值 5 NULL 探针为 0
+func (p Probe) Rarity() int {
+	return p.p.effectiveRarity()
+}
+
+func (p Probe) TotalWait() time.Duration {
+	return p.p.totalWaiTms
+}
+
+func (p Probe) TCPWrapped() time.Duration {
+	return p.p.tcpwrappedms
+}
+
+func (p Probe) NoPayload() bool {
+	return p.p.noPayload
+}
+
+func (p Probe) SourcePort() int {
+	return p.p.sourcePort
+}
+
+// IsDetector 探针由 RegisterDetector 注册的 Go 检测器实现 没有负载和规则
+func (p Probe) IsDetector() bool {
+	return p.p.detector != nil
+}
+
+func (p Probe) Fallback() []string {
+	return append([]string(nil), p.p.fallback...)
+}
+
+// Services 探针指纹中出现的全部服务 按名称排序
+func (p Probe) Services() []string {
+	services := make([]string, 0, len(p.p.services))
+	for service := range p.p.services {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	return services
+}
+
+// Rules 探针的全部 match/softmatch 规则 按文件中的顺序
+func (p Probe) Rules() []MatchRule {
+	rules := make([]MatchRule, 0, len(p.p.matchGroup))
+	for _, m := range p.p.matchGroup {
+		rules = append(rules, MatchRule{m: m, probe: p.p})
+	}
+	return rules
+}
+
+// MatchRule match/softmatch 规则的只读视图 版本字段为未替换的模板 例如 $1
+type MatchRule struct {
+	m     *match
+	probe *probe
+}
+
+// Probe 规则所属的探针
+func (r MatchRule) Probe() Probe {
+	return Probe{p: r.probe}
+}
+
+func (r MatchRule) Service() string {
+	return r.m.service
+}
+
+func (r MatchRule) Pattern() string {
+	return r.m.pattern
+}
+
+func (r MatchRule) Soft() bool {
+	return r.m.soft
+}
+
+// Line 规则在探针文件中的行号 通过接口注册的规则为 0
+func (r MatchRule) Line() int {
+	return r.m.line
+}
+
+func (r MatchRule) Product() string {
+	return r.m.versionMate.ProductName
+}
+
+func (r MatchRule) Version() string {
+	return r.m.versionMate.Version
+}
+
+func (r MatchRule) Info() string {
+	return r.m.versionMate.Info
+}
+
+func (r MatchRule) Hostname() string {
+	return r.m.versionMate.Hostname
+}
+
+func (r MatchRule) OS() string {
+	return r.m.versionMate.OperatingSystem
+}
+
+func (r MatchRule) DeviceType() string {
+	return r.m.versionMate.DeviceType
+}
+
+func (r MatchRule) CPE() []string {
+	return append([]string(nil), r.m.versionMate.CPE...)
+}
+
+// Match 使用这一条规则匹配响应 没有匹配时返回 nil
+func (r MatchRule) Match(banner []byte) *MatchResult {
+	single := &probe{Name: r.probe.Name, protocol: r.probe.protocol, matchGroup: []*match{r.m}}
+	return single.matchRules(banner)
+}
+
+// Probes 返回当前探针库中指定协议的探针 protocol 为空时返回全部 顺序与探针文件一致
+func (n *Nmap) Probes(protocol Protocol) []Probe {
+	db := n.probeDB()
+	var probes []*probe
+	switch protocol {
+	case TCP:
+		probes = db.tcpProbes
+	case UDP:
+		probes = db.udpProbes
+	default:
+		probes = append(append(probes, db.tcpProbes...), db.udpProbes...)
+	}
+	result := make([]Probe, 0, len(probes))
+	for _, p := range probes {
+		result = append(result, Probe{p: p})
+	}
+	return result
+}
+
+// FindProbe 按协议和名称查找探针
+func (n *Nmap) FindProbe(protocol Protocol, name string) (Probe, bool) {
+	for _, p := range n.probeDB().probes(protocol) {
+		if p.Name == name {
+			return Probe{p: p}, true
+		}
+	}
+	return Probe{}, false
+}
+
+// ProbesForService 返回指纹中包含该服务的探针 即可以识别该服务的探针
+func (n *Nmap) ProbesForService(protocol Protocol, service string) []Probe {
+	services := expectServices([]string{service})
+	var result []Probe
+	for _, p := range n.Probes(protocol) {
+		if p.p.identifies(services) {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// FindRules 返回满足 filter 的全部规则 protocol 为空时查询全部协议
+func (n *Nmap) FindRules(protocol Protocol, filter func(MatchRule) bool) []MatchRule {
+	var result []MatchRule
+	for _, p := range n.Probes(protocol) {
+		for _, rule := range p.Rules() {
+			if filter == nil || filter(rule) {
+				result = append(result, rule)
+			}
+		}
+	}
+	return result
+}
+
+// RulesForService 返回识别该服务的规则
+func (n *Nmap) RulesForService(protocol Protocol, service string) []MatchRule {
+	services := expectServices([]string{service})
+	return n.FindRules(protocol, func(rule MatchRule) bool {
+		_, ok := services[rule.Service()]
+		return ok
+	})
+}
+
+// RulesForProduct 返回产品名称包含 product 的规则 不区分大小写
+func (n *Nmap) RulesForProduct(protocol Protocol, product string) []MatchRule {
+	product = strings.ToLower(product)
+	return n.FindRules(protocol, func(rule MatchRule) bool {
+		return rule.Product() != "" && strings.Contains(strings.ToLower(rule.Product()), product)
+	})
+}
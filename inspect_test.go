@@ -0,0 +1,45 @@
+package gonmap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInspectProbes(t *testing.T) {
+	n := New(&Options{VersionIntensity: 7})
+	assert.Equal(t, len(n.GetTcpProbe()), len(n.Probes(TCP)))
+	assert.Equal(t, len(n.GetTcpProbe())+len(n.GetUdpProbe()), len(n.Probes("")))
+
+	pb, ok := n.FindProbe(TCP, "GetRequest")
+	if assert.True(t, ok) {
+		assert.Equal(t, TCP, pb.Protocol())
+		assert.Contains(t, string(pb.Payload()), "GET / HTTP/1.")
+		assert.Contains(t, pb.Ports(), 80)
+		assert.Contains(t, pb.Services(), "http")
+		// 返回副本 修改不影响探针库
+		pb.Ports()[0] = 0
+		assert.NotEqual(t, 0, pb.Ports()[0])
+	}
+	_, ok = n.FindProbe(UDP, "GetRequest")
+	assert.False(t, ok)
+
+	for _, p := range n.ProbesForService(TCP, "ssh") {
+		assert.Contains(t, p.Services(), "ssh")
+	}
+	rules := n.RulesForProduct(TCP, "openssh")
+	if assert.NotEmpty(t, rules) {
+		assert.Equal(t, "ssh", rules[0].Service())
+		assert.Equal(t, rules[0].Probe().Name(), "NULL")
+		assert.NotZero(t, rules[0].Line())
+	}
+	var matched *MatchResult
+	for _, rule := range n.RulesForService(TCP, "ssh") {
+		if matched = rule.Match([]byte("SSH-2.0-OpenSSH_9.6\r\n")); matched != nil {
+			break
+		}
+	}
+	if assert.NotNil(t, matched) {
+		assert.Equal(t, "OpenSSH", matched.Product)
+	}
+}
@@ -0,0 +1,153 @@
+package internal
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/projectdiscovery/gologger"
+	"github.com/tongchengbin/gonmap"
+)
+
+const (
+	ruleArchiveURL = "https://github.com/tongchengbin/appfinger/archive/refs/heads/main.zip"
+	// 规则压缩包的最大字节数
+	maxRuleArchive = 64 << 20
+)
+
+// fingerHome Web 指纹规则目录 未指定时为用户配置目录下的 gonmap/finger
+func fingerHome(options *RunnerOptions) string {
+	if options.FingerHome != "" {
+		return options.FingerHome
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "gonmap", "finger")
+}
+
+// checkFingerHome -finger-home 以前是 nmap-service-probes 文件 现在是 Web 规则目录
+// 指向文件时直接报错 避免旧的用法静默地不加载任何规则
+func checkFingerHome(options *RunnerOptions) error {
+	if options.FingerHome == "" {
+		return nil
+	}
+	info, err := os.Stat(options.FingerHome)
+	if err != nil || info.IsDir() {
+		return nil
+	}
+	data, err := os.ReadFile(options.FingerHome)
+	if err == nil && isServiceProbes(data) {
+		return fmt.Errorf("-finger-home %s is an nmap-service-probes file, use -service-probes for it, -finger-home now takes the web rules directory", options.FingerHome)
+	}
+	return fmt.Errorf("-finger-home %s is not a directory", options.FingerHome)
+}
+
+// isServiceProbes 内容中是否有 Probe 指令
+func isServiceProbes(data []byte) bool {
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "Probe TCP ") || strings.HasPrefix(line, "Probe UDP ") {
+			return true
+		}
+	}
+	return false
+}
+
+// loadWebRules 加载 Web 指纹规则 使用默认目录且目录不存在时不识别 Web 应用
+func loadWebRules(options *RunnerOptions) (*gonmap.WebRules, error) {
+	home := fingerHome(options)
+	if home == "" {
+		return nil, nil
+	}
+	if _, err := os.Stat(home); err != nil && options.FingerHome == "" {
+		gologger.Debug().Msgf("Web rules not found in %s, run with -update-rule to download", home)
+		return nil, nil
+	}
+	rules, err := gonmap.LoadWebRules(home)
+	if err != nil {
+		return nil, err
+	}
+	for _, warning := range rules.Warnings() {
+		gologger.Warning().Msgf("Web rules: %s", warning)
+	}
+	rules.DisableJavaScript = options.DisableJavaScript
+	gologger.Debug().Msgf("Loaded %d web rules from %s", rules.Len(), home)
+	return rules, nil
+}
+
+// UpdateRules 下载 appfinger 规则仓库 将其中的 yaml 文件写入规则目录
+func UpdateRules(options *RunnerOptions) error {
+	if err := checkFingerHome(options); err != nil {
+		return err
+	}
+	home := fingerHome(options)
+	if home == "" {
+		return fmt.Errorf("finger home is not set")
+	}
+	client := &http.Client{Timeout: 5 * time.Minute}
+	resp, err := client.Get(ruleArchiveURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download %s: %s", ruleArchiveURL, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxRuleArchive+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxRuleArchive {
+		return fmt.Errorf("rule archive larger than %d bytes", maxRuleArchive)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+	count := 0
+	for _, file := range archive.File {
+		ext := strings.ToLower(path.Ext(file.Name))
+		if file.FileInfo().IsDir() || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		// 去掉压缩包中的顶层目录 拒绝跳出规则目录的路径
+		name := file.Name
+		if i := strings.Index(name, "/"); i >= 0 {
+			name = name[i+1:]
+		}
+		name = path.Clean(name)
+		if path.IsAbs(name) || strings.HasPrefix(name, "../") {
+			continue
+		}
+		if err := extractRule(file, filepath.Join(home, filepath.FromSlash(name))); err != nil {
+			return err
+		}
+		count++
+	}
+	gologger.Info().Msgf("Updated %d rule files in %s", count, home)
+	return nil
+}
+
+func extractRule(file *zip.File, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return err
+	}
+	reader, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(target, data, 0644)
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"time"
 
 	"github.com/projectdiscovery/goflags"
 )
@@ -12,13 +13,15 @@ type RunnerOptions struct {
 	TargetFile        string
 	Address           goflags.StringSlice
 	Threads           int
-	Timeout           int
+	Timeout           time.Duration
 	Proxy             string
 	Output            io.Writer
 	OutputFile        string
 	OutputType        string
 	Stdin             bool
 	ServiceProbes     string
+	FingerHome        string
+	ProbeSources      goflags.StringSlice
 	Debug             bool
 	UpdateRule        bool
 	DisableIcon       bool
@@ -28,7 +31,33 @@ type RunnerOptions struct {
 	VersionIntensity  int
 	VersionTrace      bool
 	DebugReq          bool
-	ScanTimeout       int
+	ScanTimeout       time.Duration
+	ReadTimeout       time.Duration
+	HostTimeout       time.Duration
+	Ports             string
+	TopPorts          int
+	ExcludeHosts      goflags.StringSlice
+	ExcludeFile       string
+	ExcludePorts      string
+	ResolveAll        bool
+	PortDiscovery     bool
+	DiscoveryThreads  int
+	Resume            string
+	AllPorts          bool
+	Probes            goflags.StringSlice
+	ExcludeProbes     goflags.StringSlice
+	TLS               string
+	ServerName        string
+	MaxBannerSize     int
+	Verify            goflags.StringSlice
+	BannerOnly        bool
+	MaxProbes         int
+	MinConfidence     int
+	HTTPInfo          bool
+	MaxRedirects      int
+	TLSFingerprint    bool
+	SSHInfo           bool
+	SSHHostKeys       bool
 }
 
 func ParseOptions() *RunnerOptions {
@@ -39,17 +68,44 @@ func ParseOptions() *RunnerOptions {
 		flagSet.StringVarP(&options.TargetFile, "url-file", "l", "", "File containing urls to scan"),
 		flagSet.StringSliceVarP(&options.Address, "url", "t", nil, "target url to scan (-u INPUT1 -u INPUT2)", goflags.CommaSeparatedStringSliceOptions),
 		flagSet.IntVar(&options.Threads, "threads", 32, "Number of concurrent threads (default 10)"),
-		flagSet.IntVar(&options.Timeout, "timeout", 10, "Timeout in seconds (default 10)"),
+		flagSet.DurationVar(&options.Timeout, "timeout", 10*time.Second, "connect timeout (default 10s)"),
+		flagSet.DurationVar(&options.ReadTimeout, "read-timeout", 0, "per-probe read timeout (default same as -timeout)"),
+		flagSet.DurationVar(&options.ScanTimeout, "scan-timeout", 0, "total time budget for a single port (default 10x -timeout)"),
+		flagSet.DurationVar(&options.HostTimeout, "host-timeout", 0, "total time budget for all ports of a host (default unlimited)"),
 		flagSet.IntVar(&options.VersionIntensity, "version-intensity", 7, "Version intensity (default 7 max 9)"),
 		flagSet.StringVarP(&options.Proxy, "proxy", "x", "", "HTTP proxy to use for requests (e.g. http://127.0.0.1:7890)"),
 		flagSet.BoolVarP(&options.Stdin, "stdin", "s", false, "Read urls from stdin"),
-		flagSet.StringVarP(&options.ServiceProbes, "finger-home", "sp", "", "finger yaml directory home default is built-in"),
-		flagSet.BoolVarP(&options.UpdateRule, "update-rule", "ur", false, "update rule from github.com/tongchengbin/appfinger"),
+		flagSet.StringVarP(&options.Ports, "ports", "p", "", "ports to scan for targets without port (e.g. 22,80,8000-8100 or - for all)"),
+		flagSet.IntVarP(&options.TopPorts, "top-ports", "tp", 0, "scan the top N most common ports like nmap --top-ports (default 1000 when -p is not set, max 1000)"),
+		flagSet.StringSliceVarP(&options.ExcludeHosts, "exclude-hosts", "eh", nil, "hosts to exclude (ip, cidr, ip range or hostname)", goflags.CommaSeparatedStringSliceOptions),
+		flagSet.StringVarP(&options.ExcludeFile, "exclude-file", "ef", "", "file containing hosts to exclude"),
+		flagSet.StringVarP(&options.ExcludePorts, "exclude-ports", "ep", "", "ports to exclude from scan"),
+		flagSet.BoolVarP(&options.ResolveAll, "resolve-all", "ra", false, "resolve hostnames to all A/AAAA records"),
+		flagSet.BoolVarP(&options.PortDiscovery, "port-discovery", "pd", false, "run a tcp connect scan first and only detect services on open ports, closed and filtered ports are printed with their reason"),
+		flagSet.IntVarP(&options.DiscoveryThreads, "discovery-threads", "dt", 256, "number of concurrent connect scans for port discovery"),
+		flagSet.StringVarP(&options.FingerHome, "finger-home", "sp", "", "web finger yaml directory (default $CONFIG/gonmap/finger), the nmap-service-probes file moved to -service-probes"),
+		flagSet.StringVar(&options.ServiceProbes, "service-probes", "", "nmap-service-probes file replacing the built-in probes"),
+		flagSet.StringSliceVarP(&options.ProbeSources, "probe-file", "pf", nil, "extra nmap-service-probes files or directories merged on top of the base probes (reload with SIGHUP)", goflags.CommaSeparatedStringSliceOptions),
+		flagSet.BoolVarP(&options.UpdateRule, "update-rule", "ur", false, "update web finger rules from github.com/tongchengbin/appfinger into -finger-home"),
 		flagSet.BoolVarP(&options.DisableIcon, "disable-icon", "di", false, "disabled icon request to matcher"),
-		flagSet.BoolVarP(&options.DisableJavaScript, "disable-js", "dj", false, "disabled matcher javascript rule"),
+		flagSet.BoolVarP(&options.HTTPInfo, "http-info", "hi", false, "request title, headers and favicon of http services and match web finger rules (extra requests per http port)"),
+		flagSet.IntVar(&options.MaxRedirects, "max-redirects", 3, "max number of same host redirects followed for http services"),
+		flagSet.BoolVarP(&options.DisableJavaScript, "disable-js", "dj", false, "do not request scripts for web finger rules matching js"),
 		flagSet.BoolVar(&options.DebugReq, "debug-req", false, "debug request"),
 		flagSet.BoolVar(&options.DebugResp, "debug-resp", false, "debug response"),
 		flagSet.BoolVar(&options.VersionTrace, "version-trace", false, "version trace"),
+		flagSet.StringSliceVar(&options.Probes, "probes", nil, "only send these probes (e.g. NULL,GetRequest)", goflags.CommaSeparatedStringSliceOptions),
+		flagSet.StringSliceVarP(&options.ExcludeProbes, "exclude-probes", "xp", nil, "probes not to send", goflags.CommaSeparatedStringSliceOptions),
+		flagSet.StringVar(&options.TLS, "tls", "auto", "use tls for probes (auto, on, off)"),
+		flagSet.StringVar(&options.ServerName, "sni", "", "tls server name and {Host} value sent in probes (default target ip)"),
+		flagSet.BoolVarP(&options.TLSFingerprint, "tls-fingerprint", "tf", false, "compute jarm and ja3s for tls ports (10 extra connections per port)"),
+		flagSet.BoolVar(&options.SSHInfo, "ssh-info", false, "record kex, cipher, mac and compression algorithms and hassh of ssh services"),
+		flagSet.BoolVar(&options.SSHHostKeys, "ssh-hostkeys", false, "also fetch the host key of every host key algorithm (implies -ssh-info)"),
+		flagSet.IntVar(&options.MaxBannerSize, "max-banner", 4096, "max bytes read for a single probe response"),
+		flagSet.BoolVar(&options.BannerOnly, "banner", false, "fast banner mode, only send the NULL probe and the best probe for the port"),
+		flagSet.IntVar(&options.MaxProbes, "max-probes", 0, "max number of probes sent to a port (default unlimited, 2 with -banner)"),
+		flagSet.StringSliceVar(&options.Verify, "verify", nil, "only verify the targets run one of these services (e.g. ssh,http)", goflags.CommaSeparatedStringSliceOptions),
+		flagSet.BoolVar(&options.AllPorts, "allports", false, "do not skip ports excluded by the probe file Exclude directive"),
 		flagSet.BoolVarP(&options.Version, "version", "v", false, "show version"),
 	)
 	flagSet.CreateGroup("Help", "Help",
@@ -57,7 +113,9 @@ func ParseOptions() *RunnerOptions {
 	)
 	flagSet.CreateGroup("output", "Output",
 		flagSet.StringVarP(&options.OutputFile, "output", "o", "", "file to write output to"),
-		flagSet.StringVar(&options.OutputType, "output-format", "txt", "输出文件格式"),
+		flagSet.StringVar(&options.OutputType, "output-format", "txt", "输出文件格式 (txt, json, xml) txt 和 json 都是每行一个 JSON 结果"),
+		flagSet.IntVarP(&options.MinConfidence, "min-confidence", "mc", 0, "only output open ports identified with confidence at least this value (0-10), closed and filtered results are kept"),
+		flagSet.StringVar(&options.Resume, "resume", "", "resume file to record progress, existing file skips finished targets and appends to output (not with -resolve-all)"),
 	)
 	if err := flagSet.Parse(); err != nil {
 		fmt.Println(err.Error())
@@ -1,9 +1,15 @@
 package internal
 
 import (
+	"encoding/json"
+	"encoding/xml"
 	"errors"
+	"net"
 	"os"
 	"path/filepath"
+	"strings"
+
+	"github.com/tongchengbin/gonmap"
 )
 
 // OutputWriter outputs content to writers.
@@ -50,3 +56,64 @@ type OutputFields struct {
 	URL     string                       `json:"URL"`
 	Extract map[string]map[string]string `json:"Extract"`
 }
+
+// xmlPort 与 nmap XML 输出中的 port 元素对应 每个结果单独一行 便于追加写入和断点续扫
+type xmlPort struct {
+	XMLName  xml.Name    `xml:"port"`
+	Address  string      `xml:"addr,attr"`
+	Protocol string      `xml:"protocol,attr"`
+	Port     string      `xml:"portid,attr"`
+	State    xmlState    `xml:"state"`
+	Service  *xmlService `xml:"service,omitempty"`
+}
+
+type xmlState struct {
+	State  string `xml:"state,attr"`
+	Reason string `xml:"reason,attr,omitempty"`
+}
+
+type xmlService struct {
+	Name       string   `xml:"name,attr"`
+	Product    string   `xml:"product,attr,omitempty"`
+	Version    string   `xml:"version,attr,omitempty"`
+	ExtraInfo  string   `xml:"extrainfo,attr,omitempty"`
+	Hostname   string   `xml:"hostname,attr,omitempty"`
+	OS         string   `xml:"ostype,attr,omitempty"`
+	DeviceType string   `xml:"devicetype,attr,omitempty"`
+	Tunnel     string   `xml:"tunnel,attr,omitempty"`
+	Method     string   `xml:"method,attr,omitempty"`
+	Confidence int      `xml:"conf,attr,omitempty"`
+	CPE        []string `xml:"cpe,omitempty"`
+}
+
+// formatResponse 按输出格式序列化结果 支持 json 和 xml 默认的 txt 等其他格式按 json 输出
+func formatResponse(response *gonmap.Response, format string) ([]byte, error) {
+	if format != "xml" {
+		return json.Marshal(response)
+	}
+	host, port, _ := net.SplitHostPort(response.Address)
+	item := xmlPort{
+		Address:  host,
+		Protocol: strings.ToLower(string(response.Protocol)),
+		Port:     port,
+		State:    xmlState{State: string(response.Status), Reason: response.Reason},
+	}
+	if service := response.Service; service != nil {
+		item.Service = &xmlService{
+			Name:       service.Service,
+			Product:    service.Product,
+			Version:    service.Version,
+			ExtraInfo:  service.Info,
+			Hostname:   service.Hostname,
+			OS:         service.OS,
+			DeviceType: service.DeviceType,
+			Method:     string(response.Method),
+			Confidence: response.Confidence,
+			CPE:        service.CPE,
+		}
+		if response.Tls {
+			item.Service.Tunnel = "ssl"
+		}
+	}
+	return xml.Marshal(item)
+}
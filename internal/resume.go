@@ -0,0 +1,172 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// resumeState 断点续扫文件内容
+// 目标按照展开顺序编号 Offset 之前的目标全部完成 Completed 记录 Offset 之后乱序完成的目标
+type resumeState struct {
+	ConfigHash string   `json:"config_hash"`
+	Offset     uint64   `json:"offset"`
+	Completed  []uint64 `json:"completed,omitempty"`
+}
+
+// resumeTracker 记录已完成的目标 并定期写入断点文件
+type resumeTracker struct {
+	mu     sync.Mutex
+	path   string
+	hash   string
+	offset uint64
+	done   map[uint64]struct{}
+}
+
+// newResumeTracker 创建断点记录 path 存在时加载已完成的目标 配置发生变化时拒绝续扫
+func newResumeTracker(path string, hash string) (*resumeTracker, error) {
+	t := &resumeTracker{path: path, hash: hash, done: make(map[uint64]struct{})}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state resumeState
+	if err = json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("invalid resume file %s: %w", path, err)
+	}
+	if state.ConfigHash != hash {
+		return nil, fmt.Errorf("resume file %s was created with different options", path)
+	}
+	t.offset = state.Offset
+	for _, index := range state.Completed {
+		t.done[index] = struct{}{}
+	}
+	return t, nil
+}
+
+// skip 判断目标是否已在之前的扫描中完成
+func (t *resumeTracker) skip(index uint64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if index < t.offset {
+		return true
+	}
+	_, ok := t.done[index]
+	return ok
+}
+
+// complete 标记目标完成 连续完成的部分合并到 offset 中
+func (t *resumeTracker) complete(index uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.done[index] = struct{}{}
+	for {
+		if _, ok := t.done[t.offset]; !ok {
+			break
+		}
+		delete(t.done, t.offset)
+		t.offset++
+	}
+}
+
+// save 原子写入断点文件
+func (t *resumeTracker) save() error {
+	t.mu.Lock()
+	state := resumeState{ConfigHash: t.hash, Offset: t.offset}
+	for index := range t.done {
+		state.Completed = append(state.Completed, index)
+	}
+	t.mu.Unlock()
+	sort.Slice(state.Completed, func(i, j int) bool { return state.Completed[i] < state.Completed[j] })
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp := t.path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.path)
+}
+
+// remove 扫描正常结束后删除断点文件
+func (t *resumeTracker) remove() error {
+	err := os.Remove(t.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// configHash 计算影响目标展开和扫描结果的参数摘要 用于校验断点文件
+// 断点按目标展开的序号记录 目标文件和排除文件按内容计算 文件被修改后拒绝续扫
+func configHash(options *RunnerOptions) (string, error) {
+	targetFile, err := fileHash(options.TargetFile)
+	if err != nil {
+		return "", err
+	}
+	excludeFile, err := fileHash(options.ExcludeFile)
+	if err != nil {
+		return "", err
+	}
+	data, _ := json.Marshal(struct {
+		Address          []string
+		TargetFile       string
+		Ports            string
+		TopPorts         int
+		ExcludeHosts     []string
+		ExcludeFile      string
+		ExcludePorts     string
+		ResolveAll       bool
+		PortDiscovery    bool
+		ServiceProbes    string
+		ProbeSources     []string
+		VersionIntensity int
+		Probes           []string
+		ExcludeProbes    []string
+		TLS              string
+		Verify           []string
+		MaxProbes        int
+	}{
+		Address:          options.Address,
+		TargetFile:       targetFile,
+		Ports:            options.Ports,
+		TopPorts:         options.TopPorts,
+		ExcludeHosts:     options.ExcludeHosts,
+		ExcludeFile:      excludeFile,
+		ExcludePorts:     options.ExcludePorts,
+		ResolveAll:       options.ResolveAll,
+		PortDiscovery:    options.PortDiscovery,
+		ServiceProbes:    options.ServiceProbes,
+		ProbeSources:     options.ProbeSources,
+		VersionIntensity: options.VersionIntensity,
+		Probes:           options.Probes,
+		ExcludeProbes:    options.ExcludeProbes,
+		TLS:              options.TLS,
+		Verify:           options.Verify,
+		MaxProbes:        maxProbes(options),
+	})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// fileHash 计算文件内容的摘要 path 为空时返回空字符串
+func fileHash(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
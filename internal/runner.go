@@ -3,40 +3,84 @@ package internal
 import (
 	"bufio"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/logrusorgru/aurora"
 	"github.com/projectdiscovery/gologger"
 	"github.com/tongchengbin/gonmap"
 	"io"
+	"net"
 	"os"
+	"os/signal"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 )
 
+const resumeSaveInterval = 10 * time.Second
+
 type Runner struct {
 	options  *RunnerOptions
 	client   *gonmap.Nmap
+	expander *gonmap.TargetExpander
+	scan     *gonmap.ScanOptions
+	resume   *resumeTracker
 	callback func(response *gonmap.Response)
 	outputs  []io.Writer
 }
 
 func NewRunner(options *RunnerOptions) (*Runner, error) {
 	// check if finger home is set
-	client := gonmap.New(&gonmap.Options{
+	if err := checkFingerHome(options); err != nil {
+		return nil, err
+	}
+	client, err := gonmap.NewNmap(&gonmap.Options{
 		ServiceProbes:    options.ServiceProbes,
+		ProbeSources:     options.ProbeSources,
 		VersionIntensity: options.VersionIntensity,
 		VersionTrace:     options.VersionTrace,
 		DebugResponse:    options.DebugResp,
 		DebugRequest:     options.DebugReq,
 		Proxy:            options.Proxy,
-		ScanTimeout:      options.ScanTimeout,
-		Timeout:          options.Timeout,
+		AllPorts:         options.AllPorts,
+		ConnectTimeout:   options.Timeout,
+		ReadTimeout:      options.ReadTimeout,
+		PortTimeout:      options.ScanTimeout,
+		HostTimeout:      options.HostTimeout,
 	})
+	if err != nil {
+		return nil, err
+	}
+	expander, err := newTargetExpander(options)
+	if err != nil {
+		return nil, err
+	}
+	scan, err := newScanOptions(client, options)
+	if err != nil {
+		return nil, err
+	}
 	runner := &Runner{
-		options: options,
-		client:  client,
+		options:  options,
+		client:   client,
+		expander: expander,
+		scan:     scan,
+	}
+	if options.Resume != "" {
+		// -resolve-all 的解析结果顺序每次可能不同 按序号续扫会跳过错误的目标
+		if options.ResolveAll {
+			return nil, errors.New("-resume cannot be used with -resolve-all")
+		}
+		hash, err := configHash(options)
+		if err != nil {
+			return nil, err
+		}
+		runner.resume, err = newResumeTracker(options.Resume, hash)
+		if err != nil {
+			return nil, err
+		}
 	}
 	var outputs []io.Writer
 	if options.OutputFile != "" {
@@ -51,16 +95,60 @@ func NewRunner(options *RunnerOptions) (*Runner, error) {
 	}
 	runner.outputs = outputs
 	runner.callback = func(response *gonmap.Response) {
+		// 可信度只针对开放端口的识别结果 关闭 过滤 排除和出错的结果不受阈值影响
+		if options.MinConfidence > 0 && isOpenStatus(response.Status) && response.Confidence < options.MinConfidence {
+			return
+		}
 		for _, output := range outputs {
-			s, _ := json.Marshal(response)
+			s, err := formatResponse(response, options.OutputType)
+			if err != nil {
+				gologger.Warning().Msgf("Failed to format %s: %s", response.Address, err)
+				continue
+			}
 			_, _ = output.Write(append(s, "\n"...))
 		}
+		if response.Verdict != "" {
+			l := fmt.Sprintf("[%s] %s", aurora.Green(response.Address).String(), response.Verdict)
+			if response.Status == gonmap.StatusMatched {
+				l += " " + response.Service.Service
+			}
+			gologger.Info().Msgf(l)
+			return
+		}
 		if response.Status == gonmap.StatusMatched {
 			l := fmt.Sprintf("[%s] %s", aurora.Green(response.Address).String(), response.Service.Service)
 			if response.Service.Version != "" {
 				l += fmt.Sprintf(" (%s)", response.Service.Version)
 			}
+			if response.HTTP != nil {
+				l += fmt.Sprintf(" [%d] [%s]", response.HTTP.StatusCode, response.HTTP.Title)
+			}
+			for _, app := range response.Apps {
+				if app.Version != "" {
+					l += fmt.Sprintf(" [%s/%s]", app.Name, app.Version)
+				} else {
+					l += fmt.Sprintf(" [%s]", app.Name)
+				}
+			}
+			gologger.Info().Msgf(l)
+		} else if response.Method == gonmap.MethodTable {
+			// 与 nmap 一致 按端口猜测的服务名称以 ? 结尾
+			l := fmt.Sprintf("[%s] %s?", aurora.Green(response.Address).String(), response.Service.Service)
+			if len(response.Banner) > 0 {
+				l += fmt.Sprintf(" banner %q", response.Banner)
+			}
+			gologger.Info().Msgf(l)
+		} else if len(response.Banner) > 0 {
+			gologger.Info().Msgf("[%s] banner %q", aurora.Green(response.Address).String(), response.Banner)
+		} else if options.PortDiscovery && (response.Status == gonmap.StatusClose || response.Status == gonmap.StatusFiltered) {
+			// 端口发现得到的关闭和过滤端口同样输出 附带判断依据
+			l := fmt.Sprintf("[%s] %s", response.Address, response.Status)
+			if response.Reason != "" {
+				l += fmt.Sprintf(" (%s)", response.Reason)
+			}
 			gologger.Info().Msgf(l)
+		} else if response.Err != nil {
+			gologger.Debug().Msgf("[%s] %s: %s", response.Address, response.Status, response.Err)
 		}
 
 	}
@@ -68,37 +156,174 @@ func NewRunner(options *RunnerOptions) (*Runner, error) {
 
 }
 
+// isOpenStatus 端口开放时的状态 未识别的开放端口为 unknown 按端口猜测的服务也属于此类
+func isOpenStatus(status gonmap.Status) bool {
+	switch status {
+	case gonmap.StatusOpen, gonmap.StatusMatched, gonmap.StatusUnknown:
+		return true
+	}
+	return false
+}
+
+// scanPort 识别单个端口 指定 -verify 时只验证期望的服务
+func (r *Runner) scanPort(ip string, port int) *gonmap.Response {
+	if len(r.options.Verify) > 0 {
+		return r.client.Verify(context.Background(), gonmap.TCP, ip, port, r.options.Verify, r.scan)
+	}
+	return r.client.ScanWithOptions(context.Background(), gonmap.TCP, ip, port, r.scan)
+}
+
+// newScanOptions 由命令行参数生成单次扫描参数
+func newScanOptions(client *gonmap.Nmap, options *RunnerOptions) (*gonmap.ScanOptions, error) {
+	scan := client.DefaultScanOptions()
+	scan.Probes = options.Probes
+	scan.ExcludeProbes = options.ExcludeProbes
+	scan.ServerName = options.ServerName
+	scan.MaxBannerSize = options.MaxBannerSize
+	scan.MaxProbes = maxProbes(options)
+	scan.TLSFingerprint = options.TLSFingerprint
+	if options.SSHInfo || options.SSHHostKeys {
+		scan.SSH = &gonmap.SSHOptions{HostKeys: options.SSHHostKeys}
+	}
+	if options.HTTPInfo {
+		scan.HTTP = &gonmap.HTTPOptions{DisableIcon: options.DisableIcon, MaxRedirects: options.MaxRedirects}
+		// 命令行 0 表示不跟随重定向
+		if options.MaxRedirects == 0 {
+			scan.HTTP.MaxRedirects = -1
+		}
+		rules, err := loadWebRules(options)
+		if err != nil {
+			return nil, err
+		}
+		if rules != nil {
+			scan.HTTP.Fingerprinter = rules
+		}
+	}
+	switch options.TLS {
+	case "", "auto":
+		scan.TLS = gonmap.TLSAuto
+	case "on":
+		scan.TLS = gonmap.TLSOn
+	case "off":
+		scan.TLS = gonmap.TLSOff
+	default:
+		return nil, fmt.Errorf("invalid tls mode %s", options.TLS)
+	}
+	return scan, nil
+}
+
+// maxProbes -banner 模式默认只发送 NULL 探针和最适合该端口的探针
+func maxProbes(options *RunnerOptions) int {
+	if options.MaxProbes == 0 && options.BannerOnly {
+		return 2
+	}
+	return options.MaxProbes
+}
+
+// scanTarget 展开后的扫描目标 index 为展开顺序 用于断点续扫
+type scanTarget struct {
+	index   uint64
+	address string
+}
+
 func (r *Runner) EnumerateMultiple(ctx context.Context, reader io.Reader) error {
 	scanner := bufio.NewScanner(reader)
-	targets := make(chan string, 10)
+	addresses := make(chan scanTarget, 10)
+	targets := make(chan scanTarget, 10)
 	var wg sync.WaitGroup
 	for i := 0; i < r.options.Threads; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			for address := range targets {
-				response, err := r.client.ScanAddress(gonmap.TCP, address)
+			for target := range targets {
+				// 中断后只消费队列 未扫描的目标留给下次续扫
+				if ctx.Err() != nil {
+					continue
+				}
+				ip, port, err := gonmap.ParseAddress(target.address)
 				if err != nil {
-					gologger.Warning().Msgf("Failed to scan %s: %s\n", address, err)
+					gologger.Warning().Msgf("Failed to scan %s: %s\n", target.address, err)
+				} else {
+					r.callback(r.scanPort(ip, port))
+				}
+				r.complete(target)
+			}
+		}()
+	}
+	// 开启端口发现时 只有开放的端口才会进入服务识别
+	var discoveryWg sync.WaitGroup
+	if r.options.PortDiscovery {
+		timeout := r.options.Timeout
+		for i := 0; i < r.options.DiscoveryThreads; i++ {
+			discoveryWg.Add(1)
+			go func() {
+				defer discoveryWg.Done()
+				for target := range addresses {
+					if ctx.Err() != nil {
+						continue
+					}
+					ip, port, err := gonmap.ParseAddress(target.address)
+					if err != nil {
+						gologger.Warning().Msgf("Failed to scan %s: %s\n", target.address, err)
+						r.complete(target)
+						continue
+					}
+					response := r.client.ConnectScan(ctx, ip, port, timeout)
+					if response.Status == gonmap.StatusOpen {
+						targets <- target
+						continue
+					}
+					r.callback(response)
+					r.complete(target)
 				}
-				r.callback(response)
+			}()
+		}
+	} else {
+		discoveryWg.Add(1)
+		go func() {
+			defer discoveryWg.Done()
+			for target := range addresses {
+				targets <- target
 			}
 		}()
 	}
-	for scanner.Scan() {
-		target, err := sanitize(scanner.Text())
+	var index uint64
+	for scanner.Scan() && ctx.Err() == nil {
+		input, err := sanitize(scanner.Text())
 		if err != nil {
 			continue
 		}
-		targets <- target
+		err = r.expander.Expand(ctx, input, func(host string, port int) error {
+			target := scanTarget{index: index, address: net.JoinHostPort(host, strconv.Itoa(port))}
+			index++
+			if r.resume != nil && r.resume.skip(target.index) {
+				return nil
+			}
+			addresses <- target
+			return nil
+		})
+		if err != nil && ctx.Err() == nil {
+			gologger.Warning().Msgf("Failed to expand %s: %s\n", input, err)
+		}
 	}
+	close(addresses)
+	discoveryWg.Wait()
 	close(targets)
 	wg.Wait()
 	return nil
 }
 
+// complete 记录目标完成 用于断点续扫
+func (r *Runner) complete(target scanTarget) {
+	if r.resume != nil {
+		r.resume.complete(target.index)
+	}
+}
+
 func (r *Runner) Enumerate() error {
-	ctx := context.Background()
+	// 收到中断信号后停止派发新目标 等待正在扫描的目标完成后保存断点
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 	if r.options.OutputFile != "" {
 		outputWriter := NewOutputWriter(true)
 		file, err := outputWriter.createFile(r.options.OutputFile, true)
@@ -111,6 +336,35 @@ func (r *Runner) Enumerate() error {
 			return err
 		}
 	}
+	// 第一次中断后恢复默认信号处理 再次中断可以强制退出
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	go r.reloadOnHangup(ctx)
+	if r.resume == nil {
+		return r.enumerate(ctx)
+	}
+	saveCtx, cancelSave := context.WithCancel(ctx)
+	saveDone := make(chan struct{})
+	go func() {
+		defer close(saveDone)
+		r.saveResumePeriodically(saveCtx)
+	}()
+	err := r.enumerate(ctx)
+	cancelSave()
+	<-saveDone
+	if ctx.Err() != nil || err != nil {
+		if saveErr := r.resume.save(); saveErr != nil {
+			return saveErr
+		}
+		gologger.Info().Msgf("Scan interrupted, resume with: -resume %s", r.options.Resume)
+		return err
+	}
+	return r.resume.remove()
+}
+
+func (r *Runner) enumerate(ctx context.Context) error {
 	// If we have multiple domains as input,
 	if len(r.options.Address) > 0 {
 		reader := strings.NewReader(strings.Join(r.options.Address, "\n"))
@@ -131,6 +385,50 @@ func (r *Runner) Enumerate() error {
 	return nil
 }
 
+// reloadOnHangup 收到 SIGHUP 时重新加载探针库 不影响正在进行的扫描
+func (r *Runner) reloadOnHangup(ctx context.Context) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			if err := r.client.Reload(); err != nil {
+				gologger.Warning().Msgf("Failed to reload probes: %s", err)
+				continue
+			}
+			gologger.Info().Msgf("Probes reloaded")
+		}
+	}
+}
+
+// saveResumePeriodically 定期保存断点 避免进程被强制结束时丢失进度
+func (r *Runner) saveResumePeriodically(ctx context.Context) {
+	ticker := time.NewTicker(resumeSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.resume.save(); err != nil {
+				gologger.Warning().Msgf("Failed to save resume file: %s", err)
+			}
+		}
+	}
+}
+
+// Close 关闭输出文件
+func (r *Runner) Close() {
+	for _, output := range r.outputs {
+		if closer, ok := output.(io.Closer); ok {
+			_ = closer.Close()
+		}
+	}
+}
+
 var (
 	ErrEmptyInput = errors.New("empty data")
 )
@@ -142,3 +440,51 @@ func sanitize(data string) (string, error) {
 	}
 	return data, nil
 }
+
+// newTargetExpander 根据命令行参数创建目标展开器
+func newTargetExpander(options *RunnerOptions) (*gonmap.TargetExpander, error) {
+	targetOptions := &gonmap.TargetOptions{
+		ExcludeHosts: options.ExcludeHosts,
+		ResolveAll:   options.ResolveAll,
+	}
+	var err error
+	if options.Ports != "" {
+		targetOptions.Ports, err = gonmap.ParsePorts(options.Ports)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if options.TopPorts > 0 || options.Ports == "" {
+		topPorts := options.TopPorts
+		if topPorts <= 0 {
+			topPorts = 1000
+		}
+		ports := gonmap.TopPorts(topPorts, gonmap.TCP)
+		if len(ports) < topPorts {
+			gologger.Warning().Msgf("-top-ports %d exceeds the %d ranked tcp ports, scanning all of them", topPorts, len(ports))
+		}
+		for _, port := range ports {
+			if !slices.Contains(targetOptions.Ports, port) {
+				targetOptions.Ports = append(targetOptions.Ports, port)
+			}
+		}
+	}
+	if options.ExcludePorts != "" {
+		targetOptions.ExcludePorts, err = gonmap.ParsePorts(options.ExcludePorts)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if options.ExcludeFile != "" {
+		data, err := os.ReadFile(options.ExcludeFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if line, err = sanitize(line); err == nil {
+				targetOptions.ExcludeHosts = append(targetOptions.ExcludeHosts, line)
+			}
+		}
+	}
+	return gonmap.NewTargetExpander(targetOptions)
+}
@@ -0,0 +1,299 @@
+package gonmap
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+)
+
+// jarmProbe JARM 的一个 ClientHello 配置 与 salesforce/jarm 中的探测顺序和参数一致
+type jarmProbe struct {
+	version uint16
+	// 为 true 时不包含 TLS 1.3 的套件
+	noTLS13Ciphers bool
+	cipherOrder    string
+	grease         bool
+	rareALPN       bool
+	// 1.2 1.3 为空时不发送 supported_versions
+	support        string
+	extensionOrder string
+}
+
+const (
+	versionTLS11 = 0x0302
+	versionTLS12 = 0x0303
+	versionTLS13 = 0x0304
+)
+
+var jarmProbes = []jarmProbe{
+	{version: versionTLS12, cipherOrder: "FORWARD", support: "1.2", extensionOrder: "REVERSE"},
+	{version: versionTLS12, cipherOrder: "REVERSE", support: "1.2", extensionOrder: "FORWARD"},
+	{version: versionTLS12, cipherOrder: "TOP_HALF", extensionOrder: "FORWARD"},
+	{version: versionTLS12, cipherOrder: "BOTTOM_HALF", rareALPN: true, extensionOrder: "FORWARD"},
+	{version: versionTLS12, cipherOrder: "MIDDLE_OUT", grease: true, rareALPN: true, extensionOrder: "REVERSE"},
+	{version: versionTLS11, cipherOrder: "FORWARD", extensionOrder: "FORWARD"},
+	{version: versionTLS13, cipherOrder: "FORWARD", support: "1.3", extensionOrder: "REVERSE"},
+	{version: versionTLS13, cipherOrder: "REVERSE", support: "1.3", extensionOrder: "FORWARD"},
+	{version: versionTLS13, noTLS13Ciphers: true, cipherOrder: "FORWARD", support: "1.3", extensionOrder: "FORWARD"},
+	{version: versionTLS13, cipherOrder: "MIDDLE_OUT", grease: true, support: "1.3", extensionOrder: "REVERSE"},
+}
+
+// jarmCiphers JARM 发送的全部套件 按发送顺序
+var jarmCiphers = []uint16{
+	0x0016, 0x0033, 0x0067, 0xc09e, 0xc0a2, 0x009e, 0x0039, 0x006b, 0xc09f, 0xc0a3, 0x009f, 0x0045, 0x00be, 0x0088,
+	0x00c4, 0x009a, 0xc008, 0xc009, 0xc023, 0xc0ac, 0xc0ae, 0xc02b, 0xc00a, 0xc024, 0xc0ad, 0xc0af, 0xc02c, 0xc072,
+	0xc073, 0xcca9, 0x1302, 0x1301, 0xcc14, 0xc007, 0xc012, 0xc013, 0xc027, 0xc02f, 0xc014, 0xc028, 0xc030, 0xc060,
+	0xc061, 0xc076, 0xc077, 0xcca8, 0x1305, 0x1304, 0x1303, 0xcc13, 0xc011, 0x000a, 0x002f, 0x003c, 0xc09c, 0xc0a0,
+	0x009c, 0x0035, 0x003d, 0xc09d, 0xc0a1, 0x009d, 0x0041, 0x00ba, 0x0084, 0x00c0, 0x0007, 0x0004, 0x0005,
+}
+
+// jarmCipherIndex 计算 JARM 哈希时套件的编号 按数值排序
+var jarmCipherIndex = []uint16{
+	0x0004, 0x0005, 0x0007, 0x000a, 0x0016, 0x002f, 0x0033, 0x0035, 0x0039, 0x003c, 0x003d, 0x0041, 0x0045, 0x0067,
+	0x006b, 0x0084, 0x0088, 0x009a, 0x009c, 0x009d, 0x009e, 0x009f, 0x00ba, 0x00be, 0x00c0, 0x00c4, 0xc007, 0xc008,
+	0xc009, 0xc00a, 0xc011, 0xc012, 0xc013, 0xc014, 0xc023, 0xc024, 0xc027, 0xc028, 0xc02b, 0xc02c, 0xc02f, 0xc030,
+	0xc060, 0xc061, 0xc072, 0xc073, 0xc076, 0xc077, 0xc09c, 0xc09d, 0xc09e, 0xc09f, 0xc0a0, 0xc0a1, 0xc0a2, 0xc0a3,
+	0xc0ac, 0xc0ad, 0xc0ae, 0xc0af, 0xcc13, 0xcc14, 0xcca8, 0xcca9, 0x1301, 0x1302, 0x1303, 0x1304, 0x1305,
+}
+
+var greaseValues = []uint16{
+	0x0a0a, 0x1a1a, 0x2a2a, 0x3a3a, 0x4a4a, 0x5a5a, 0x6a6a, 0x7a7a, 0x8a8a, 0x9a9a, 0xaaaa, 0xbaba, 0xcaca, 0xdada, 0xeaea, 0xfafa,
+}
+
+var (
+	alpnAll  = []string{"http/0.9", "http/1.0", "http/1.1", "spdy/1", "spdy/2", "spdy/3", "h2", "h2c", "hq"}
+	alpnRare = []string{"http/0.9", "http/1.0", "spdy/1", "spdy/2", "spdy/3", "h2c", "hq"}
+)
+
+// jarmEmpty 没有任何响应时的 JARM
+const jarmEmpty = "00000000000000000000000000000000000000000000000000000000000000"
+
+// mung 按 JARM 的规则重新排列
+func mung[T any](items []T, order string) []T {
+	n := len(items)
+	var output []T
+	switch order {
+	case "REVERSE":
+		for i := n - 1; i >= 0; i-- {
+			output = append(output, items[i])
+		}
+	case "BOTTOM_HALF":
+		output = append(output, items[n/2+n%2:]...)
+	case "TOP_HALF":
+		// 数量为奇数时包含中间的元素
+		if n%2 == 1 {
+			output = append(output, items[n/2])
+		}
+		output = append(output, mung(mung(items, "REVERSE"), "BOTTOM_HALF")...)
+	case "MIDDLE_OUT":
+		middle := n / 2
+		if n%2 == 1 {
+			output = append(output, items[middle])
+			for i := 1; i <= middle; i++ {
+				output = append(output, items[middle+i], items[middle-i])
+			}
+		} else {
+			for i := 1; i <= middle; i++ {
+				output = append(output, items[middle-1+i], items[middle-i])
+			}
+		}
+	default:
+		output = append(output, items...)
+	}
+	return output
+}
+
+func randomGrease() uint16 {
+	var b [1]byte
+	_, _ = rand.Read(b[:])
+	return greaseValues[int(b[0])%len(greaseValues)]
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return b
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return binary.BigEndian.AppendUint16(b, v)
+}
+
+// clientHello 按 JARM 探测参数构造 TLS 记录 serverName 为 SNI
+func (p jarmProbe) clientHello(serverName string) []byte {
+	recordVersion, helloVersion := p.version, p.version
+	if p.version == versionTLS13 {
+		recordVersion, helloVersion = 0x0301, versionTLS12
+	}
+	hello := appendUint16(nil, helloVersion)
+	hello = append(hello, randomBytes(32)...)
+	hello = append(hello, 32)
+	hello = append(hello, randomBytes(32)...)
+
+	ciphers := jarmCiphers
+	if p.noTLS13Ciphers {
+		ciphers = nil
+		for _, c := range jarmCiphers {
+			if c>>8 != 0x13 {
+				ciphers = append(ciphers, c)
+			}
+		}
+	}
+	ciphers = mung(ciphers, p.cipherOrder)
+	if p.grease {
+		ciphers = append([]uint16{randomGrease()}, ciphers...)
+	}
+	hello = appendUint16(hello, uint16(len(ciphers)*2))
+	for _, c := range ciphers {
+		hello = appendUint16(hello, c)
+	}
+	// 一种压缩方法 null
+	hello = append(hello, 1, 0)
+
+	extensions := p.extensions(serverName)
+	hello = appendUint16(hello, uint16(len(extensions)))
+	hello = append(hello, extensions...)
+
+	handshake := []byte{1, byte(len(hello) >> 16), byte(len(hello) >> 8), byte(len(hello))}
+	handshake = append(handshake, hello...)
+	record := []byte{0x16}
+	record = appendUint16(record, recordVersion)
+	record = appendUint16(record, uint16(len(handshake)))
+	return append(record, handshake...)
+}
+
+func (p jarmProbe) extensions(serverName string) []byte {
+	var ext []byte
+	if p.grease {
+		ext = appendUint16(ext, randomGrease())
+		ext = append(ext, 0, 0)
+	}
+	// server_name
+	ext = append(ext, 0, 0)
+	ext = appendUint16(ext, uint16(len(serverName)+5))
+	ext = appendUint16(ext, uint16(len(serverName)+3))
+	ext = append(ext, 0)
+	ext = appendUint16(ext, uint16(len(serverName)))
+	ext = append(ext, serverName...)
+	// extended_master_secret max_fragment_length renegotiation_info supported_groups ec_point_formats session_ticket
+	ext = append(ext, 0x00, 0x17, 0x00, 0x00)
+	ext = append(ext, 0x00, 0x01, 0x00, 0x01, 0x01)
+	ext = append(ext, 0xff, 0x01, 0x00, 0x01, 0x00)
+	ext = append(ext, 0x00, 0x0a, 0x00, 0x0a, 0x00, 0x08, 0x00, 0x1d, 0x00, 0x17, 0x00, 0x18, 0x00, 0x19)
+	ext = append(ext, 0x00, 0x0b, 0x00, 0x02, 0x01, 0x00)
+	ext = append(ext, 0x00, 0x23, 0x00, 0x00)
+	// application_layer_protocol_negotiation
+	alpns := alpnAll
+	if p.rareALPN {
+		alpns = alpnRare
+	}
+	var alpn []byte
+	for _, proto := range mung(alpns, p.extensionOrder) {
+		alpn = append(alpn, byte(len(proto)))
+		alpn = append(alpn, proto...)
+	}
+	ext = append(ext, 0x00, 0x10)
+	ext = appendUint16(ext, uint16(len(alpn)+2))
+	ext = appendUint16(ext, uint16(len(alpn)))
+	ext = append(ext, alpn...)
+	// signature_algorithms
+	ext = append(ext, 0x00, 0x0d, 0x00, 0x14, 0x00, 0x12, 0x04, 0x03, 0x08, 0x04, 0x04, 0x01, 0x05, 0x03, 0x08, 0x05, 0x05, 0x01, 0x08, 0x06, 0x06, 0x01, 0x02, 0x01)
+	// key_share x25519
+	var share []byte
+	if p.grease {
+		share = appendUint16(share, randomGrease())
+		share = append(share, 0x00, 0x01, 0x00)
+	}
+	share = append(share, 0x00, 0x1d, 0x00, 0x20)
+	share = append(share, randomBytes(32)...)
+	ext = append(ext, 0x00, 0x33)
+	ext = appendUint16(ext, uint16(len(share)+2))
+	ext = appendUint16(ext, uint16(len(share)))
+	ext = append(ext, share...)
+	// psk_key_exchange_modes
+	ext = append(ext, 0x00, 0x2d, 0x00, 0x02, 0x01, 0x01)
+	// supported_versions
+	if p.version == versionTLS13 || p.support == "1.2" {
+		versions := []uint16{0x0301, 0x0302, 0x0303}
+		if p.support != "1.2" {
+			versions = append(versions, versionTLS13)
+		}
+		versions = mung(versions, p.extensionOrder)
+		if p.grease {
+			versions = append([]uint16{randomGrease()}, versions...)
+		}
+		ext = append(ext, 0x00, 0x2b)
+		ext = appendUint16(ext, uint16(len(versions)*2+1))
+		ext = append(ext, byte(len(versions)*2))
+		for _, v := range versions {
+			ext = appendUint16(ext, v)
+		}
+	}
+	return ext
+}
+
+// jarmResult 解析单个探测的响应 格式为 cipher|version|alpn|extensions
+// 没有 ServerHello 时为 |||
+func jarmResult(data []byte) string {
+	hello, err := parseServerHello(data)
+	if err != nil {
+		return "|||"
+	}
+	result := hex.EncodeToString(appendUint16(nil, hello.cipher)) + "|" + hex.EncodeToString(appendUint16(nil, hello.version)) + "|"
+	if !hello.hasExtensions {
+		return result + "|"
+	}
+	types := make([]string, 0, len(hello.extensions))
+	for _, ext := range hello.extensions {
+		types = append(types, hex.EncodeToString(appendUint16(nil, ext)))
+	}
+	return result + hello.alpn + "|" + strings.Join(types, "-")
+}
+
+// jarmHash 由 10 个探测结果计算 JARM
+// 前 30 个字符为每个探测选择的套件和版本 后 32 个字符为 ALPN 和扩展的 sha256 前缀
+func jarmHash(results []string) string {
+	empty := true
+	for _, result := range results {
+		if result != "|||" {
+			empty = false
+		}
+	}
+	if empty {
+		return jarmEmpty
+	}
+	var fuzzy strings.Builder
+	var alpnAndExt strings.Builder
+	for _, result := range results {
+		components := strings.SplitN(result, "|", 4)
+		fuzzy.WriteString(jarmCipherByte(components[0]))
+		fuzzy.WriteString(jarmVersionByte(components[1]))
+		alpnAndExt.WriteString(components[2])
+		alpnAndExt.WriteString(components[3])
+	}
+	sum := sha256.Sum256([]byte(alpnAndExt.String()))
+	return fuzzy.String() + hex.EncodeToString(sum[:])[:32]
+}
+
+func jarmCipherByte(cipher string) string {
+	if cipher == "" {
+		return "00"
+	}
+	count := 1
+	for _, c := range jarmCipherIndex {
+		if hex.EncodeToString(appendUint16(nil, c)) == cipher {
+			break
+		}
+		count++
+	}
+	return hex.EncodeToString([]byte{byte(count)})
+}
+
+func jarmVersionByte(version string) string {
+	if len(version) < 4 || version[3] < '0' || version[3] > '5' {
+		return "0"
+	}
+	return string("abcdef"[version[3]-'0'])
+}
@@ -0,0 +1,98 @@
+package gonmap
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/projectdiscovery/gologger"
+)
+
+// Logger 库内部日志输出 通过 Options.Logger 或 ScanOptions.Logger 注入
+// VersionTrace DebugRequest DebugResponse 的输出使用 Infof
+type Logger interface {
+	Debugf(format string, args ...any)
+	Infof(format string, args ...any)
+	Warnf(format string, args ...any)
+	Errorf(format string, args ...any)
+}
+
+// NewGologgerLogger 使用 gologger 输出日志 logger 为空时使用 gologger.DefaultLogger
+// Options.Logger 为空时的默认实现
+func NewGologgerLogger(logger *gologger.Logger) Logger {
+	return gologgerLogger{logger: logger}
+}
+
+type gologgerLogger struct {
+	logger *gologger.Logger
+}
+
+func (l gologgerLogger) get() *gologger.Logger {
+	if l.logger == nil {
+		return gologger.DefaultLogger
+	}
+	return l.logger
+}
+
+func (l gologgerLogger) Debugf(format string, args ...any) {
+	l.get().Debug().Msgf(format, args...)
+}
+
+func (l gologgerLogger) Infof(format string, args ...any) {
+	l.get().Info().Msgf(format, args...)
+}
+
+func (l gologgerLogger) Warnf(format string, args ...any) {
+	l.get().Warning().Msgf(format, args...)
+}
+
+func (l gologgerLogger) Errorf(format string, args ...any) {
+	l.get().Error().Msgf(format, args...)
+}
+
+// NewSlogLogger 使用 log/slog 输出日志 logger 为空时使用 slog.Default()
+func NewSlogLogger(logger *slog.Logger) Logger {
+	return slogLogger{logger: logger}
+}
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+func (l slogLogger) log(level slog.Level, format string, args ...any) {
+	logger := l.logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	ctx := context.Background()
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+	logger.Log(ctx, level, fmt.Sprintf(format, args...))
+}
+
+func (l slogLogger) Debugf(format string, args ...any) {
+	l.log(slog.LevelDebug, format, args...)
+}
+
+func (l slogLogger) Infof(format string, args ...any) {
+	l.log(slog.LevelInfo, format, args...)
+}
+
+func (l slogLogger) Warnf(format string, args ...any) {
+	l.log(slog.LevelWarn, format, args...)
+}
+
+func (l slogLogger) Errorf(format string, args ...any) {
+	l.log(slog.LevelError, format, args...)
+}
+
+// NopLogger 丢弃所有日志
+var NopLogger Logger = nopLogger{}
+
+type nopLogger struct{}
+
+func (nopLogger) Debugf(string, ...any) {}
+func (nopLogger) Infof(string, ...any)  {}
+func (nopLogger) Warnf(string, ...any)  {}
+func (nopLogger) Errorf(string, ...any) {}
@@ -0,0 +1,59 @@
+package gonmap
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *recordLogger) record(format string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func (l *recordLogger) Debugf(format string, args ...any) { l.record(format, args...) }
+func (l *recordLogger) Infof(format string, args ...any)  { l.record(format, args...) }
+func (l *recordLogger) Warnf(format string, args ...any)  { l.record(format, args...) }
+func (l *recordLogger) Errorf(format string, args ...any) { l.record(format, args...) }
+
+func TestLogger(t *testing.T) {
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := closed.Addr().(*net.TCPAddr).Port
+	_ = closed.Close()
+
+	global := &recordLogger{}
+	n := New(&Options{VersionIntensity: 7, Timeout: 1, VersionTrace: true, Logger: global})
+	assert.NotEmpty(t, global.lines)
+
+	// 单次扫描的日志不写入全局日志
+	global.lines = nil
+	perScan := &recordLogger{}
+	options := n.DefaultScanOptions()
+	options.Logger = perScan
+	n.ScanWithOptions(context.Background(), TCP, "127.0.0.1", port, options)
+	assert.Empty(t, global.lines)
+	assert.Contains(t, perScan.lines[0], "Service scan sending probe NULL")
+
+	var buf bytes.Buffer
+	logger := NewSlogLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelWarn})))
+	logger.Debugf("hidden %d", 1)
+	logger.Warnf("shown %d", 2)
+	assert.NotContains(t, buf.String(), "hidden")
+	assert.Contains(t, buf.String(), "shown 2")
+	NopLogger.Errorf("discarded")
+}
@@ -2,8 +2,11 @@ package gonmap
 
 import (
 	"errors"
+	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
+	"unicode"
 
 	"github.com/dlclark/regexp2"
 )
@@ -18,6 +21,7 @@ type versionMate struct {
 	Hostname         string
 	OperatingSystem  string
 	DeviceType       string
+	CPE              []string
 	match            *match
 }
 
@@ -31,15 +35,6 @@ type match struct {
 	line        int
 }
 
-var matchVersionInfoRegexps = map[string]*regexp.Regexp{
-	"PRODUCTNAME": regexp.MustCompile("p/([^/]+)/"),
-	"VERSION":     regexp.MustCompile("v/([^/]+)/"),
-	"INFO":        regexp.MustCompile("i/([^/]+)/"),
-	"HOSTNAME":    regexp.MustCompile("h/([^/]+)/"),
-	"OS":          regexp.MustCompile("o/([^/]+)/"),
-	"DEVICE":      regexp.MustCompile("d/([^/]+)/"),
-}
-
 func FixProtocol(oldProtocol string) string {
 	//进行最后输出修饰
 	if oldProtocol == "ssl/http" {
@@ -84,104 +79,186 @@ func FixProtocol(oldProtocol string) string {
 	return oldProtocol
 }
 
-func extractCPEValue(part string) string {
-	start := len("cpe:/a:")
-	end := strings.Index(part[start:], "/") + start
-	if end > start {
-		return part[start:end]
+// readDelimited 读取 <delim>value<delim> 返回值和剩余字符串 s 以分隔符开头
+func readDelimited(s string) (string, string, error) {
+	if len(s) < 2 {
+		return "", "", errors.New("missing delimiter")
 	}
-	return part[start:]
-}
-func extractValues(s string) map[string]string {
-	values := make(map[string]string)
-	parts := strings.Split(s, " ")
-
-	for _, part := range parts {
-		if strings.HasPrefix(part, "p/") {
-			values["p"] = extractValue(part, "p/")
-		} else if strings.HasPrefix(part, "v/") {
-			values["v"] = extractValue(part, "v/")
-		} else if strings.HasPrefix(part, "i/") {
-			values["i"] = extractValue(part, "i/")
-		} else if strings.HasPrefix(part, "cpe:/a:") {
-			values["cpe"] = extractCPEValue(part)
-		}
+	delim := s[:1]
+	end := strings.Index(s[1:], delim)
+	if end < 0 {
+		return "", "", fmt.Errorf("unterminated value %s", s)
 	}
-
-	return values
+	return s[1 : end+1], s[end+2:], nil
 }
 
-func extractValue(part, prefix string) string {
-	start := len(prefix)
-	end := strings.Index(part[start:], "/") + start
-	if end > start {
-		return part[start:end]
+// readFlags 读取紧跟在分隔符之后的选项字符 例如正则的 si 以及 cpe 的 a
+func readFlags(s string) (string, string) {
+	i := 0
+	for i < len(s) && s[i] != ' ' && s[i] != '\t' {
+		i++
 	}
-	return part[start:]
+	return s[:i], s[i:]
 }
 
-func parseMatch(s string, soft bool) *match {
-	var m = &match{}
+// parseMatch 解析 match/softmatch 指令参数
+// <service> m<d><pattern><d>[opts] [p<d>..<d>] [v<d>..<d>] [i<d>..<d>] [h<d>..<d>] [o<d>..<d>] [d<d>..<d>] [cpe:<d>..<d>[a]]
+func parseMatch(s string, soft bool) (*match, error) {
+	var m = &match{soft: soft}
 	// 查找第一个空格前的字符串
 	index := strings.Index(s, " ")
-	m.service = s[:index]
-	s = strings.Trim(s[index+1:], " ")
+	if index <= 0 {
+		return nil, errors.New("match 语句参数不正确: " + s)
+	}
+	m.service = FixProtocol(s[:index])
+	s = strings.TrimLeft(s[index+1:], " ")
 	// 查找匹配的正则
-	if s[:1] != "m" {
-		panic(errors.New("match 语句参数不正确: " + s[:1]))
-		return nil
+	if !strings.HasPrefix(s, "m") {
+		return nil, errors.New("match 语句参数不正确: " + s)
+	}
+	pattern, rest, err := readDelimited(s[1:])
+	if err != nil {
+		return nil, err
 	}
-	var mf = s[1:2]
-	var mStart = 2
-	// 找到结束符
-	var end = strings.Index(s[mStart:], mf)
-	var pattern = s[mStart : mStart+end]
-	// 判断是否有选项
-	var patternOpt string
-	if len(s) > (mStart+end+2) && s[mStart+end+1:mStart+end+2] != " " {
-		patternOpt = s[mStart+end+1 : mStart+end+2]
-	} else {
-		patternOpt = ""
-	}
-	s = s[mStart+end+len(patternOpt):]
-
-	m.soft = soft
-	m.service = FixProtocol(m.service)
+	patternOpt, rest := readFlags(rest)
 	m.pattern = pattern
-	m.regex = getPatternRegexp(pattern, patternOpt)
-	meta := extractValues(s)
-	m.versionMate = &versionMate{}
-	for k, v := range meta {
-		switch k {
+	m.regex, err = getPatternRegexp(pattern, patternOpt)
+	if err != nil {
+		return nil, err
+	}
+	m.versionMate, err = parseVersionInfo(rest)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// parseVersionInfo 解析版本信息字段 字段分隔符可以是任意字符
+func parseVersionInfo(s string) (*versionMate, error) {
+	vm := &versionMate{}
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			return vm, nil
+		}
+		var key string
+		if strings.HasPrefix(s, "cpe:") {
+			key, s = "cpe", s[len("cpe:"):]
+		} else {
+			key, s = s[:1], s[1:]
+		}
+		value, rest, err := readDelimited(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version info %s: %w", key, err)
+		}
+		_, s = readFlags(rest)
+		switch key {
 		case "p":
-			m.versionMate.ProductName = v
+			vm.ProductName = value
 		case "v":
-			m.versionMate.Version = v
+			vm.Version = value
 		case "i":
-			m.versionMate.Info = v
+			vm.Info = value
+		case "h":
+			vm.Hostname = value
+		case "o":
+			vm.OperatingSystem = value
+		case "d":
+			vm.DeviceType = value
 		case "cpe":
-			m.versionMate.ProbeName = v
+			vm.CPE = append(vm.CPE, "cpe:/"+value)
+		default:
+			return nil, fmt.Errorf("unknown version info field %s", key)
 		}
 	}
-	return m
 }
-func getPatternRegexp(pattern string, opt string) *regexp2.Regexp {
+
+func getPatternRegexp(pattern string, opt string) (*regexp2.Regexp, error) {
 	pattern = strings.ReplaceAll(pattern, `\0`, `\x00`)
 	var o regexp2.RegexOptions
-	switch opt {
-	case "i":
-		o = regexp2.IgnoreCase
-	case "s":
-		o = regexp2.Singleline
-	default:
-		o = regexp2.None
-	}
-	return regexp2.MustCompile(pattern, o)
+	for _, c := range opt {
+		switch c {
+		case 'i':
+			o |= regexp2.IgnoreCase
+		case 's':
+			o |= regexp2.Singleline
+		default:
+			return nil, fmt.Errorf("unknown regex option %c", c)
+		}
+	}
+	return regexp2.Compile(pattern, o)
 }
-func (m *match) getVersionInfo(s string, regID string) string {
-	if matchVersionInfoRegexps[regID].MatchString(s) {
-		return matchVersionInfoRegexps[regID].FindStringSubmatch(s)[1]
-	} else {
+
+// bytesToRunes 将每个字节映射为同值的字符 使 \xff 等二进制模式按字节匹配
+func bytesToRunes(data []byte) []rune {
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return runes
+}
+
+func runesToBytes(runes []rune) []byte {
+	data := make([]byte, len(runes))
+	for i, r := range runes {
+		data[i] = byte(r)
+	}
+	return data
+}
+
+var templateRegx = regexp.MustCompile(`\$(?:(\d)|P\((\d)\)|SUBST\((\d),"([^"]*)","([^"]*)"\)|I\((\d),"([<>])"\))`)
+
+// expandTemplate 替换版本信息中的 $1 $P(1) $SUBST(1,"a","b") $I(1,">") 模板
+func expandTemplate(template string, groups [][]byte) string {
+	if !strings.Contains(template, "$") {
+		return template
+	}
+	group := func(s string) []byte {
+		i, _ := strconv.Atoi(s)
+		if i < len(groups) {
+			return groups[i]
+		}
+		return nil
+	}
+	return templateRegx.ReplaceAllStringFunc(template, func(s string) string {
+		sub := templateRegx.FindStringSubmatch(s)
+		switch {
+		case sub[1] != "":
+			return string(group(sub[1]))
+		case sub[2] != "":
+			return printable(group(sub[2]))
+		case sub[3] != "":
+			return strings.ReplaceAll(string(group(sub[3])), sub[4], sub[5])
+		case sub[6] != "":
+			return unpackInt(group(sub[6]), sub[7] == "<")
+		}
+		return s
+	})
+}
+
+// printable 只保留可打印字符 对应 nmap 的 $P()
+func printable(data []byte) string {
+	var builder strings.Builder
+	for _, b := range data {
+		if b < 128 && unicode.IsPrint(rune(b)) {
+			builder.WriteByte(b)
+		}
+	}
+	return builder.String()
+}
+
+// unpackInt 将最多 8 字节按大端或小端解析为无符号整数 对应 nmap 的 $I()
+func unpackInt(data []byte, littleEndian bool) string {
+	if len(data) == 0 || len(data) > 8 {
 		return ""
 	}
+	var v uint64
+	for i := range data {
+		b := data[i]
+		if littleEndian {
+			b = data[len(data)-1-i]
+		}
+		v = v<<8 | uint64(b)
+	}
+	return strconv.FormatUint(v, 10)
 }
@@ -0,0 +1,249 @@
+package gonmap
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+)
+
+const (
+	mongoOpReply = 1
+	mongoOpQuery = 2004
+	mongoOpMsg   = 2013
+	// 支持 OP_MSG 的最小 wire version 对应 3.6
+	mongoOpMsgWireVersion = 6
+	// 单个响应的最大长度
+	maxMongoMessage = 64 * 1024
+)
+
+var errBSON = errors.New("bson document malformed")
+
+// mongoDetector 使用 OP_QUERY 发送握手 isMaster 再发送 buildInfo 解析版本和副本集信息
+type mongoDetector struct{}
+
+func (mongoDetector) Name() string       { return "MongoDBHello" }
+func (mongoDetector) Protocol() Protocol { return TCP }
+func (mongoDetector) Ports() []int       { return []int{27017} }
+func (mongoDetector) Services() []string { return []string{"mongodb"} }
+func (mongoDetector) Rarity() int        { return 8 }
+
+func (mongoDetector) Detect(ctx context.Context, conn net.Conn, target Target) (*MatchResult, error) {
+	hello, response, err := mongoCommand(conn, 1, "isMaster")
+	if hello == nil {
+		return nil, err
+	}
+	if _, ok := hello["maxWireVersion"]; !ok {
+		if _, ok := hello["ismaster"]; !ok {
+			return nil, nil
+		}
+	}
+	details := map[string]any{}
+	for key, name := range map[string]string{
+		"ismaster":       "is_master",
+		"maxWireVersion": "max_wire_version",
+		"minWireVersion": "min_wire_version",
+		"setName":        "set_name",
+		"hosts":          "hosts",
+		"primary":        "primary",
+		"secondary":      "secondary",
+		"msg":            "msg",
+	} {
+		if value, ok := hello[key]; ok {
+			details[name] = value
+		}
+	}
+	result := &MatchResult{Service: "mongodb", Product: "MongoDB", Response: response, Details: details}
+	if setName, ok := hello["setName"].(string); ok {
+		result.Info = "replica set: " + setName
+	} else if hello["msg"] == "isdbgrid" {
+		result.Info = "mongos"
+	}
+	// buildInfo 失败时保留 isMaster 的结果
+	build := mongoBuildInfo(conn, hello)
+	if version, ok := build["version"].(string); ok {
+		result.Version = version
+		details["version"] = version
+		if gitVersion, ok := build["gitVersion"].(string); ok {
+			details["git_version"] = gitVersion
+		}
+		result.CPE = []string{fmt.Sprintf("cpe:/a:mongodb:mongodb:%s", version)}
+	} else if message, ok := build["errmsg"].(string); ok {
+		details["build_info_error"] = message
+	}
+	return result, nil
+}
+
+// mongoBuildInfo 支持 OP_MSG 的服务端(3.6 以上)使用 OP_MSG 发送 buildInfo
+// 6.0 以上的服务端只允许握手使用 OP_QUERY 旧版本回退到 OP_QUERY
+func mongoBuildInfo(conn net.Conn, hello map[string]any) map[string]any {
+	if wire, ok := hello["maxWireVersion"].(int32); ok && wire >= mongoOpMsgWireVersion {
+		build, _, _ := mongoMsg(conn, 2, "buildInfo")
+		return build
+	}
+	build, _, _ := mongoCommand(conn, 2, "buildInfo")
+	return build
+}
+
+// mongoCommand 使用 OP_QUERY 向 admin.$cmd 发送 {command: 1} 返回响应文档和原始响应
+func mongoCommand(conn net.Conn, requestID uint32, command string) (map[string]any, []byte, error) {
+	body := binary.LittleEndian.AppendUint32(nil, 0)
+	body = append(body, "admin.$cmd\x00"...)
+	// numberToSkip numberToReturn
+	body = binary.LittleEndian.AppendUint32(body, 0)
+	body = binary.LittleEndian.AppendUint32(body, 0xffffffff)
+	body = append(body, bsonCommand(command, "")...)
+	// OP_REPLY flags(4) cursorID(8) startingFrom(4) numberReturned(4)
+	return mongoRoundTrip(conn, requestID, mongoOpQuery, body, mongoOpReply, 20)
+}
+
+// mongoMsg 使用 OP_MSG 发送 {command: 1, $db: "admin"}
+func mongoMsg(conn net.Conn, requestID uint32, command string) (map[string]any, []byte, error) {
+	// flagBits(4) 之后是 kind 0 的 body section
+	body := append(binary.LittleEndian.AppendUint32(nil, 0), 0)
+	body = append(body, bsonCommand(command, "admin")...)
+	return mongoRoundTrip(conn, requestID, mongoOpMsg, body, mongoOpMsg, 5)
+}
+
+// mongoRoundTrip 发送一个消息并读取响应 skip 为响应中文档之前的字节数
+// 响应不是对应的消息或文档无法解析时返回 nil
+func mongoRoundTrip(conn net.Conn, requestID uint32, opCode uint32, body []byte, replyOpCode uint32, skip int) (map[string]any, []byte, error) {
+	message := binary.LittleEndian.AppendUint32(nil, uint32(16+len(body)))
+	message = binary.LittleEndian.AppendUint32(message, requestID)
+	message = binary.LittleEndian.AppendUint32(message, 0)
+	message = binary.LittleEndian.AppendUint32(message, opCode)
+	if _, err := conn.Write(append(message, body...)); err != nil {
+		return nil, nil, err
+	}
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, nil, err
+	}
+	length := int(binary.LittleEndian.Uint32(header))
+	if length < 16+skip+5 || length > maxMongoMessage ||
+		binary.LittleEndian.Uint32(header[8:]) != requestID || binary.LittleEndian.Uint32(header[12:]) != replyOpCode {
+		return nil, nil, nil
+	}
+	reply := make([]byte, length-16)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return nil, nil, err
+	}
+	doc, err := parseBSON(reply[skip:])
+	if err != nil {
+		return nil, nil, nil
+	}
+	return doc, append(header, reply...), nil
+}
+
+// bsonCommand 命令文档 {command: int32(1)} db 不为空时加上 $db
+func bsonCommand(command, db string) []byte {
+	doc := []byte{0x10}
+	doc = append(append(doc, command...), 0)
+	doc = binary.LittleEndian.AppendUint32(doc, 1)
+	if db != "" {
+		doc = append(append(append(doc, 0x02), "$db"...), 0)
+		doc = binary.LittleEndian.AppendUint32(doc, uint32(len(db)+1))
+		doc = append(append(doc, db...), 0)
+	}
+	doc = append(doc, 0)
+	return append(binary.LittleEndian.AppendUint32(nil, uint32(len(doc)+4)), doc...)
+}
+
+// parseBSON 解析 BSON 文档 只保留常见类型的值 其他类型跳过
+func parseBSON(data []byte) (map[string]any, error) {
+	if len(data) < 5 {
+		return nil, errBSON
+	}
+	size := int(binary.LittleEndian.Uint32(data))
+	if size < 5 || size > len(data) || data[size-1] != 0 {
+		return nil, errBSON
+	}
+	data = data[4 : size-1]
+	doc := make(map[string]any)
+	for len(data) > 0 {
+		kind := data[0]
+		end := bytes.IndexByte(data[1:], 0)
+		if end < 0 {
+			return doc, errBSON
+		}
+		name := string(data[1 : 1+end])
+		data = data[2+end:]
+		var width int
+		switch kind {
+		case 0x01:
+			width = 8
+			if len(data) >= width {
+				doc[name] = math.Float64frombits(binary.LittleEndian.Uint64(data))
+			}
+		case 0x02:
+			if len(data) < 4 {
+				return doc, errBSON
+			}
+			width = 4 + int(binary.LittleEndian.Uint32(data))
+			if width > 4 && len(data) >= width {
+				doc[name] = string(data[4 : width-1])
+			}
+		case 0x03, 0x04:
+			if len(data) < 4 {
+				return doc, errBSON
+			}
+			width = int(binary.LittleEndian.Uint32(data))
+			nested, err := parseBSON(data)
+			if err != nil {
+				return doc, err
+			}
+			if kind == 0x03 {
+				doc[name] = nested
+			} else {
+				items := make([]any, 0, len(nested))
+				for i := 0; i < len(nested); i++ {
+					items = append(items, nested[fmt.Sprint(i)])
+				}
+				doc[name] = items
+			}
+		case 0x05:
+			if len(data) < 4 {
+				return doc, errBSON
+			}
+			width = 5 + int(binary.LittleEndian.Uint32(data))
+		case 0x07:
+			width = 12
+		case 0x08:
+			width = 1
+			if len(data) >= width {
+				doc[name] = data[0] == 1
+			}
+		case 0x09, 0x11:
+			width = 8
+		case 0x0a:
+			doc[name] = nil
+		case 0x10:
+			width = 4
+			if len(data) >= width {
+				doc[name] = int32(binary.LittleEndian.Uint32(data))
+			}
+		case 0x12:
+			width = 8
+			if len(data) >= width {
+				doc[name] = int64(binary.LittleEndian.Uint64(data))
+			}
+		case 0x13:
+			width = 16
+		default:
+			return doc, errBSON
+		}
+		if width < 0 || len(data) < width {
+			return doc, errBSON
+		}
+		data = data[width:]
+	}
+	return doc, nil
+}
+
+func init() {
+	mustRegisterDetector(mongoDetector{})
+}
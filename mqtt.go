@@ -0,0 +1,51 @@
+package gonmap
+
+import (
+	"context"
+	"io"
+	"net"
+)
+
+// mqttConnect MQTT 3.1.1 CONNECT 报文 clean session keepalive 60 客户端标识 gonmap
+var mqttConnect = []byte("\x10\x12\x00\x04MQTT\x04\x02\x00\x3c\x00\x06gonmap")
+
+// mqttDetector 发送 CONNECT 并解析 CONNACK 返回码 区分是否允许匿名连接
+type mqttDetector struct{}
+
+func (mqttDetector) Name() string       { return "MQTTConnect" }
+func (mqttDetector) Protocol() Protocol { return TCP }
+func (mqttDetector) Ports() []int       { return []int{1883} }
+func (mqttDetector) SSLPorts() []int    { return []int{8883} }
+func (mqttDetector) Services() []string { return []string{"mqtt"} }
+func (mqttDetector) Rarity() int        { return 8 }
+
+func (mqttDetector) Detect(ctx context.Context, conn net.Conn, target Target) (*MatchResult, error) {
+	if _, err := conn.Write(mqttConnect); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 4)
+	n, err := io.ReadFull(conn, buf)
+	if err != nil {
+		return nil, err
+	}
+	// CONNACK 固定头 0x20 剩余长度 2
+	if buf[0] != 0x20 || buf[1] != 0x02 {
+		return nil, nil
+	}
+	result := &MatchResult{Service: "mqtt", Response: buf[:n]}
+	switch buf[3] {
+	case 0:
+		result.Info = "anonymous access"
+	case 1:
+		result.Info = "unacceptable protocol version"
+	case 4, 5:
+		result.Info = "auth required"
+	default:
+		result.Info = "connection refused"
+	}
+	return result, nil
+}
+
+func init() {
+	mustRegisterDetector(mqttDetector{})
+}
@@ -0,0 +1,129 @@
+package gonmap
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+// tdsReply TDS 响应报文类型
+const tdsReply = 0x04
+
+// mssqlPreLogin TDS PRELOGIN 请求 VERSION ENCRYPTION INSTOPT THREADID MARS
+var mssqlPreLogin = []byte{
+	0x12, 0x01, 0x00, 0x2f, 0x00, 0x00, 0x01, 0x00,
+	0x00, 0x00, 0x1a, 0x00, 0x06,
+	0x01, 0x00, 0x20, 0x00, 0x01,
+	0x02, 0x00, 0x21, 0x00, 0x01,
+	0x03, 0x00, 0x22, 0x00, 0x04,
+	0x04, 0x00, 0x26, 0x00, 0x01,
+	0xff,
+	0x09, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00,
+	0x00,
+	0x00, 0x00, 0x00, 0x00,
+	0x00,
+}
+
+// mssqlEncryption PRELOGIN ENCRYPTION 选项
+var mssqlEncryption = map[byte]string{
+	0: "off",
+	1: "on",
+	2: "not supported",
+	3: "required",
+}
+
+// mssqlReleases 主版本号对应的发行版本
+var mssqlReleases = map[string]string{
+	"8.0":   "2000",
+	"9.0":   "2005",
+	"10.0":  "2008",
+	"10.50": "2008 R2",
+	"11.0":  "2012",
+	"12.0":  "2014",
+	"13.0":  "2016",
+	"14.0":  "2017",
+	"15.0":  "2019",
+	"16.0":  "2022",
+}
+
+// mssqlDetector 发送 PRELOGIN 解析服务端版本和加密要求
+type mssqlDetector struct{}
+
+func (mssqlDetector) Name() string       { return "MSSQLPreLogin" }
+func (mssqlDetector) Protocol() Protocol { return TCP }
+func (mssqlDetector) Ports() []int       { return []int{1433} }
+func (mssqlDetector) Services() []string { return []string{"ms-sql-s"} }
+func (mssqlDetector) Rarity() int        { return 8 }
+
+func (mssqlDetector) Detect(ctx context.Context, conn net.Conn, target Target) (*MatchResult, error) {
+	if _, err := conn.Write(mssqlPreLogin); err != nil {
+		return nil, err
+	}
+	// type(1) status(1) length(2) spid(2) packet_id(1) window(1)
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	length := int(binary.BigEndian.Uint16(header[2:]))
+	if header[0] != tdsReply || length <= 8 || length > 4096 {
+		return nil, nil
+	}
+	payload := make([]byte, length-8)
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return nil, err
+	}
+	options := parsePreLogin(payload)
+	version := options[0]
+	if len(version) < 6 {
+		return nil, nil
+	}
+	major, minor := version[0], version[1]
+	build := binary.BigEndian.Uint16(version[2:])
+	subBuild := binary.BigEndian.Uint16(version[4:])
+	details := map[string]any{
+		"version": fmt.Sprintf("%d.%d.%d.%d", major, minor, build, subBuild),
+	}
+	result := &MatchResult{
+		Service:  "ms-sql-s",
+		Product:  "Microsoft SQL Server",
+		Version:  fmt.Sprintf("%d.%02d.%d.%02d", major, minor, build, subBuild),
+		Response: append(header, payload...),
+		Details:  details,
+	}
+	if release, ok := mssqlReleases[fmt.Sprintf("%d.%d", major, minor)]; ok {
+		details["release"] = release
+		result.Product = "Microsoft SQL Server " + release
+		result.CPE = []string{"cpe:/a:microsoft:sql_server:" + release}
+	}
+	if value := options[1]; len(value) == 1 {
+		if encryption, ok := mssqlEncryption[value[0]]; ok {
+			details["encryption"] = encryption
+			result.Info = "encryption: " + encryption
+		}
+	}
+	if value := options[2]; len(value) > 1 {
+		details["instance"] = string(value[:len(value)-1])
+	}
+	return result, nil
+}
+
+// parsePreLogin 解析 PRELOGIN 选项 token(1) offset(2) length(2) 直到 0xff
+func parsePreLogin(payload []byte) map[byte][]byte {
+	options := make(map[byte][]byte)
+	for i := 0; i+5 <= len(payload) && payload[i] != 0xff; i += 5 {
+		offset := int(binary.BigEndian.Uint16(payload[i+1:]))
+		length := int(binary.BigEndian.Uint16(payload[i+3:]))
+		if offset+length > len(payload) {
+			break
+		}
+		options[payload[i]] = payload[offset : offset+length]
+	}
+	return options
+}
+
+func init() {
+	mustRegisterDetector(mssqlDetector{})
+}
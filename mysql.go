@@ -0,0 +1,128 @@
+package gonmap
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+const (
+	// CLIENT_SSL CLIENT_PLUGIN_AUTH 能力位
+	mysqlCapabilitySSL        = 0x00000800
+	mysqlCapabilityPluginAuth = 0x00080000
+	// MariaDB 为兼容旧客户端在版本号前加的前缀
+	mariaDBVersionPrefix = "5.5.5-"
+)
+
+// mysqlDetector 读取服务端的初始握手报文 解析版本 能力位和认证插件
+type mysqlDetector struct{}
+
+func (mysqlDetector) Name() string       { return "MySQLHandshake" }
+func (mysqlDetector) Protocol() Protocol { return TCP }
+func (mysqlDetector) Ports() []int       { return []int{3306} }
+func (mysqlDetector) Services() []string { return []string{"mysql"} }
+func (mysqlDetector) Rarity() int        { return 8 }
+
+func (mysqlDetector) Detect(ctx context.Context, conn net.Conn, target Target) (*MatchResult, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	// length(3) sequence(1) 握手报文序号为 0
+	length := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	if header[3] != 0 || length < 3 || length > 1024 {
+		return nil, nil
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return nil, err
+	}
+	response := append(header, payload...)
+	// ERR_Packet 例如 Host is not allowed to connect
+	if payload[0] == 0xff {
+		code := binary.LittleEndian.Uint16(payload[1:])
+		message := strings.TrimPrefix(string(payload[3:]), "#")
+		if len(message) < 5 || !isPrintable(message) {
+			return nil, nil
+		}
+		return &MatchResult{
+			Service:  "mysql",
+			Info:     "unauthorized",
+			Response: response,
+			Details:  map[string]any{"error_code": code, "error": message},
+		}, nil
+	}
+	if payload[0] != 10 {
+		return nil, nil
+	}
+	end := bytes.IndexByte(payload[1:], 0)
+	if end < 1 {
+		return nil, nil
+	}
+	version := string(payload[1 : 1+end])
+	rest := payload[2+end:]
+	// thread_id(4) auth_data_1(8) filler(1) capability_low(2)
+	if !isPrintable(version) || len(rest) < 15 {
+		return nil, nil
+	}
+	details := map[string]any{
+		"protocol_version": 10,
+		"version":          version,
+		"thread_id":        binary.LittleEndian.Uint32(rest),
+	}
+	capabilities := uint32(binary.LittleEndian.Uint16(rest[13:]))
+	// charset(1) status(2) capability_high(2) auth_data_len(1) reserved(10) auth_data_2
+	if len(rest) >= 31 {
+		details["charset"] = rest[15]
+		capabilities |= uint32(binary.LittleEndian.Uint16(rest[18:])) << 16
+		authData := int(rest[20]) - 8
+		if authData < 13 {
+			authData = 13
+		}
+		if plugin := rest[31:]; capabilities&mysqlCapabilityPluginAuth != 0 && len(plugin) > authData {
+			plugin = plugin[authData:]
+			if i := bytes.IndexByte(plugin, 0); i >= 0 {
+				plugin = plugin[:i]
+			}
+			details["auth_plugin"] = string(plugin)
+		}
+	}
+	details["capabilities"] = capabilities
+	details["ssl"] = capabilities&mysqlCapabilitySSL != 0
+
+	result := &MatchResult{Service: "mysql", Response: response, Details: details}
+	if strings.Contains(strings.ToLower(version), "mariadb") {
+		result.Product = "MariaDB"
+		result.Version = strings.TrimPrefix(version, mariaDBVersionPrefix)
+		if i := strings.Index(result.Version, "-"); i > 0 {
+			result.Version = result.Version[:i]
+		}
+		result.CPE = []string{fmt.Sprintf("cpe:/a:mariadb:mariadb:%s", result.Version)}
+	} else {
+		result.Product = "MySQL"
+		result.Version = version
+		if i := strings.Index(version, "-"); i > 0 {
+			result.Version = version[:i]
+		}
+		result.CPE = []string{fmt.Sprintf("cpe:/a:mysql:mysql:%s", result.Version)}
+	}
+	return result, nil
+}
+
+// isPrintable 字符串只包含可打印的 ASCII 字符
+func isPrintable(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func init() {
+	mustRegisterDetector(mysqlDetector{})
+}
@@ -1,77 +1,82 @@
 package gonmap
 
 import (
-	"os"
+	"sync"
+	"sync/atomic"
 
-	"github.com/projectdiscovery/gologger"
 	"golang.org/x/net/proxy"
 )
 
 type Nmap struct {
-	probeNameMap map[string]*probe
-	tcpProbes    []*probe
-	udpProbes    []*probe
-	rarity       int
-	ShowBanner   bool
-	dialer       proxy.Dialer
-	option       *Options
+	db         atomic.Pointer[probeDB]
+	rarity     int
+	ShowBanner bool
+	dialer     proxy.Dialer
+	option     *Options
+	hosts      *hostBudgets
+	// 保护探针库的修改 以及通过接口注册的探针和规则 Reload 后重新应用
+	mu            sync.Mutex
+	registrations []func(db *probeDB) error
 }
 
 func New(option *Options) *Nmap {
-	nmap := &Nmap{
-		probeNameMap: make(map[string]*probe),
-		option:       option,
-	}
-	err := nmap.init()
+	nmap, err := NewNmap(option)
 	if err != nil {
 		panic(err)
 	}
 	return nmap
 }
 
-func (n *Nmap) init() error {
-	var probeList []*probe
-	if n.option.ServiceProbes == "" {
-		probeList = LoadProbes(probes, n.option.VersionIntensity)
-	} else {
-		probesData, err := os.ReadFile(n.option.ServiceProbes)
-		if err != nil {
-			return err
-		}
-		probeList = LoadProbes(string(probesData), n.option.VersionIntensity)
+// NewNmap 与 New 相同 探针库加载失败时返回错误
+func NewNmap(option *Options) (*Nmap, error) {
+	nmap := &Nmap{
+		option: option,
+		hosts:  newHostBudgets(),
+	}
+	if err := nmap.Reload(); err != nil {
+		return nil, err
 	}
-	for _, p := range probeList {
-		if p.protocol == TCP {
-			n.tcpProbes = append(n.tcpProbes, p)
-		} else {
-			n.udpProbes = append(n.udpProbes, p)
+	return nmap, nil
+}
+
+// Reload 重新加载全部探针源并原子替换探针库
+// 正在进行的扫描继续使用旧的探针库 新的扫描使用新的探针库 加载失败时保留旧的探针库
+func (n *Nmap) Reload() error {
+	db, err := loadProbeDB(n.option)
+	if err != nil {
+		return wrapError(ErrProbeDB, err)
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, apply := range n.registrations {
+		if err := apply(db); err != nil {
+			db.warnings = append(db.warnings, "registered probe: "+err.Error())
 		}
 	}
-	n.setFallback(n.tcpProbes)
-	gologger.Debug().Msgf("Loaded %d tcp probes and %d udp probes", len(n.tcpProbes), len(n.udpProbes))
+	setFallback(db.tcpProbes)
+	setFallback(db.udpProbes)
+	n.db.Store(db)
+	for _, warning := range db.warnings {
+		n.option.logger().Warnf("Probe database: %s", warning)
+	}
+	n.option.logger().Debugf("Loaded %d tcp probes and %d udp probes", len(db.tcpProbes), len(db.udpProbes))
 	return nil
 }
 
-func (n *Nmap) GetUdpProbe() []*probe {
-	return n.udpProbes
-}
-func (n *Nmap) GetTcpProbe() []*probe {
-	return n.tcpProbes
+// ProbeWarnings 返回加载探针库时遇到的警告 例如无法识别的指令
+func (n *Nmap) ProbeWarnings() []string {
+	return append([]string(nil), n.probeDB().warnings...)
 }
 
-func (n *Nmap) setFallback(ps []*probe) {
-	probeMap := make(map[string]*probe)
-	for _, p := range ps {
-		probeMap[p.Name] = p
-	}
-	for _, pb := range ps {
-		for _, fb := range pb.fallback {
-			if _, ok := probeMap[fb]; ok {
-				pb.fallbackProbe = append(pb.fallbackProbe, probeMap[fb])
-			}
+func (n *Nmap) probeDB() *probeDB {
+	return n.db.Load()
+}
 
-		}
-	}
+func (n *Nmap) GetUdpProbe() []*probe {
+	return n.probeDB().udpProbes
+}
+func (n *Nmap) GetTcpProbe() []*probe {
+	return n.probeDB().tcpProbes
 }
 
 //func (n *Nmap) ScanTCP(ctx context.Context, ip string, port int) (response *Response) {
@@ -85,12 +90,7 @@ func (n *Nmap) setFallback(ps []*probe) {
 func (n *Nmap) Match(protocol Protocol, banner []byte, firstProbe string) *MatchResult {
 	// Service scan match (Probe HTTPOptions matched with NULL line 3571): 103.133.154.250:2222 is ssh.  Version: |OpenSSH|9.2p1|protocol 2.0|
 	//	Nmap 匹配指纹不一定是对应的探针
-	var ms []*probe
-	if protocol == TCP {
-		ms = n.tcpProbes
-	} else {
-		ms = n.udpProbes
-	}
+	ms := n.probeDB().probes(protocol)
 	for _, p := range ms {
 		if p.Name == firstProbe {
 			if f := p.match(banner); f != nil {
@@ -101,6 +101,8 @@ func (n *Nmap) Match(protocol Protocol, banner []byte, firstProbe string) *Match
 	for _, p := range ms {
 		if p.Name != firstProbe {
 			if f := p.match(banner); f != nil {
+				f.Probe = firstProbe
+				f.Fallback = true
 				return f
 			}
 		}
@@ -0,0 +1,103 @@
+package gonmap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf16"
+)
+
+var ntlmSignature = []byte("NTLMSSP\x00")
+
+var errNTLMChallenge = errors.New("ntlm challenge malformed")
+
+// ntlmNegotiate NTLMSSP NEGOTIATE_MESSAGE 请求 Unicode NTLM 和版本信息 不包含域和工作站
+var ntlmNegotiate = []byte("NTLMSSP\x00\x01\x00\x00\x00\xb7\x82\x08\xe2" +
+	"\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00" +
+	"\x0a\x00\x63\x45\x00\x00\x00\x0f")
+
+// NTLMInfo NTLM CHALLENGE_MESSAGE 中的主机信息
+type NTLMInfo struct {
+	NetBIOSComputerName string `json:"netbios_computer_name,omitempty"`
+	NetBIOSDomainName   string `json:"netbios_domain_name,omitempty"`
+	DNSComputerName     string `json:"dns_computer_name,omitempty"`
+	DNSDomainName       string `json:"dns_domain_name,omitempty"`
+	DNSTreeName         string `json:"dns_tree_name,omitempty"`
+	// 操作系统版本 例如 10.0.17763
+	OSVersion string `json:"os_version,omitempty"`
+	// 服务端时间 CHALLENGE 中没有时间戳时为 nil
+	SystemTime *time.Time `json:"system_time,omitempty"`
+}
+
+// parseNTLMChallenge 在 data 中查找 NTLMSSP CHALLENGE_MESSAGE 并解析 TargetInfo 和版本
+func parseNTLMChallenge(data []byte) (*NTLMInfo, error) {
+	start := bytes.Index(data, ntlmSignature)
+	if start < 0 {
+		return nil, errNTLMChallenge
+	}
+	msg := data[start:]
+	// Signature(8) MessageType(4) TargetName(8) Flags(4) Challenge(8) Reserved(8) TargetInfo(8) Version(8)
+	if len(msg) < 48 || binary.LittleEndian.Uint32(msg[8:]) != 2 {
+		return nil, errNTLMChallenge
+	}
+	info := &NTLMInfo{}
+	flags := binary.LittleEndian.Uint32(msg[20:])
+	// NTLMSSP_NEGOTIATE_VERSION
+	if flags&0x02000000 != 0 && len(msg) >= 56 {
+		info.OSVersion = fmt.Sprintf("%d.%d.%d", msg[48], msg[49], binary.LittleEndian.Uint16(msg[50:]))
+	}
+	length := int(binary.LittleEndian.Uint16(msg[40:]))
+	offset := int(binary.LittleEndian.Uint32(msg[44:]))
+	if offset+length > len(msg) {
+		return info, errNTLMChallenge
+	}
+	pairs := msg[offset : offset+length]
+	for len(pairs) >= 4 {
+		id := binary.LittleEndian.Uint16(pairs)
+		size := int(binary.LittleEndian.Uint16(pairs[2:]))
+		if id == 0 || len(pairs) < 4+size {
+			break
+		}
+		value := pairs[4 : 4+size]
+		switch id {
+		case 1:
+			info.NetBIOSComputerName = decodeUTF16(value)
+		case 2:
+			info.NetBIOSDomainName = decodeUTF16(value)
+		case 3:
+			info.DNSComputerName = decodeUTF16(value)
+		case 4:
+			info.DNSDomainName = decodeUTF16(value)
+		case 5:
+			info.DNSTreeName = decodeUTF16(value)
+		case 7:
+			if size == 8 {
+				info.SystemTime = fileTime(binary.LittleEndian.Uint64(value))
+			}
+		}
+		pairs = pairs[4+size:]
+	}
+	return info, nil
+}
+
+// decodeUTF16 解码 UTF-16LE 字符串
+func decodeUTF16(b []byte) string {
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(b[i*2:])
+	}
+	return string(utf16.Decode(units))
+}
+
+// fileTime Windows FILETIME 1601-01-01 起的 100 纳秒数 早于 1970 年的时间视为无效 返回 nil
+func fileTime(ft uint64) *time.Time {
+	const epochDiff = 116444736000000000
+	if ft < epochDiff {
+		return nil
+	}
+	ft -= epochDiff
+	t := time.Unix(int64(ft/1e7), int64(ft%1e7)*100).UTC()
+	return &t
+}
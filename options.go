@@ -1,12 +1,68 @@
 package gonmap
 
+import "time"
+
 type Options struct {
-	ServiceProbes    string
+	ServiceProbes    string   // 替换内置探针库的文件
+	ProbeSources     []string // 叠加在基础探针库之上的探针文件或目录 按顺序加载
 	VersionIntensity int
 	VersionTrace     bool
 	DebugResponse    bool
 	DebugRequest     bool
 	Proxy            string
-	ScanTimeout      int // 单个扫描目标的超时时间
-	Timeout          int // 连接超时时间
+	AllPorts         bool // 忽略探针文件中的 Exclude 指令 识别所有端口
+	ScanTimeout      int  // 单个端口扫描的总超时时间(秒) 兼容旧配置 优先使用 PortTimeout
+	Timeout          int  // 连接超时时间(秒) 兼容旧配置 优先使用 ConnectTimeout
+
+	ConnectTimeout time.Duration // 建立连接(包括 TLS 握手)的超时时间
+	ReadTimeout    time.Duration // 单个探针等待响应的超时时间 探针 totalwaitms 更小时以探针为准
+	PortTimeout    time.Duration // 单个端口识别的总预算
+	HostTimeout    time.Duration // 同一主机所有端口识别的总预算 为空时不限制
+
+	Logger Logger // 库内部日志 为空时使用 gologger.DefaultLogger 可以使用 NopLogger 关闭
+
+	DisableDetectors bool // 不加载通过 RegisterDetector 注册的检测器 只使用探针文件
+}
+
+func (o *Options) logger() Logger {
+	if o.Logger == nil {
+		return NewGologgerLogger(nil)
+	}
+	return o.Logger
+}
+
+// Timeouts 扫描使用的超时模型
+type Timeouts struct {
+	Connect time.Duration
+	Read    time.Duration
+	Port    time.Duration
+	Host    time.Duration
+}
+
+const defaultConnectTimeout = 10 * time.Second
+
+// timeouts 合并新旧配置 得到最终生效的超时时间
+func (o *Options) timeouts() Timeouts {
+	t := Timeouts{
+		Connect: o.ConnectTimeout,
+		Read:    o.ReadTimeout,
+		Port:    o.PortTimeout,
+		Host:    o.HostTimeout,
+	}
+	if t.Connect <= 0 {
+		t.Connect = time.Duration(o.Timeout) * time.Second
+	}
+	if t.Connect <= 0 {
+		t.Connect = defaultConnectTimeout
+	}
+	if t.Read <= 0 {
+		t.Read = t.Connect
+	}
+	if t.Port <= 0 {
+		t.Port = time.Duration(o.ScanTimeout) * time.Second
+	}
+	if t.Port <= 0 {
+		t.Port = t.Connect * 10
+	}
+	return t
 }
@@ -0,0 +1,144 @@
+package gonmap
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+)
+
+// postgresSSLRequest SSLRequest 报文 长度 8 请求码 80877103
+var postgresSSLRequest = []byte{0x00, 0x00, 0x00, 0x08, 0x04, 0xd2, 0x16, 0x2f}
+
+// postgresAuthMethods AuthenticationRequest 中的认证方式
+var postgresAuthMethods = map[uint32]string{
+	0:  "trust",
+	2:  "kerberos",
+	3:  "password",
+	5:  "md5",
+	7:  "gss",
+	9:  "sspi",
+	10: "sasl",
+}
+
+// postgresDetector 发送 SSLRequest 和 StartupMessage 解析认证请求或错误响应
+type postgresDetector struct{}
+
+func (postgresDetector) Name() string       { return "PostgreSQLStartup" }
+func (postgresDetector) Protocol() Protocol { return TCP }
+func (postgresDetector) Ports() []int       { return []int{5432} }
+func (postgresDetector) Services() []string { return []string{"postgresql"} }
+func (postgresDetector) Rarity() int        { return 9 }
+
+func (postgresDetector) Detect(ctx context.Context, conn net.Conn, target Target) (*MatchResult, error) {
+	if _, err := conn.Write(postgresSSLRequest); err != nil {
+		return nil, err
+	}
+	reply := make([]byte, 1)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return nil, err
+	}
+	details := map[string]any{}
+	switch reply[0] {
+	case 'S':
+		details["ssl"] = true
+		tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true, ServerName: target.ServerName})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return nil, err
+		}
+		conn = tlsConn
+	case 'N':
+		details["ssl"] = false
+	default:
+		return nil, nil
+	}
+	if _, err := conn.Write(postgresStartup("postgres", "postgres")); err != nil {
+		return nil, err
+	}
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	length := int(binary.BigEndian.Uint32(header[1:]))
+	if (header[0] != 'R' && header[0] != 'E') || length < 4 || length > 8192 {
+		return nil, nil
+	}
+	body := make([]byte, length-4)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return nil, err
+	}
+	result := &MatchResult{Service: "postgresql", Product: "PostgreSQL DB", Response: append(header, body...), Details: details}
+	if header[0] == 'R' {
+		if len(body) < 4 {
+			return nil, nil
+		}
+		code := binary.BigEndian.Uint32(body)
+		method, ok := postgresAuthMethods[code]
+		if !ok {
+			return nil, nil
+		}
+		details["auth_method"] = method
+		if code == 10 {
+			details["sasl_mechanisms"] = cStrings(body[4:])
+		}
+		result.Info = "auth: " + method
+		return result, nil
+	}
+	fields := postgresErrorFields(body)
+	if fields['S'] == "" || fields['C'] == "" {
+		return nil, nil
+	}
+	details["error_code"] = fields['C']
+	details["error"] = fields['M']
+	// 错误位置可以用于区分版本
+	for key, name := range map[byte]string{'F': "error_file", 'L': "error_line", 'R': "error_routine"} {
+		if fields[key] != "" {
+			details[name] = fields[key]
+		}
+	}
+	result.Info = fields['M']
+	return result, nil
+}
+
+// postgresStartup 协议 3.0 的 StartupMessage
+func postgresStartup(user, database string) []byte {
+	body := binary.BigEndian.AppendUint32(nil, 196608)
+	for _, value := range []string{"user", user, "database", database, "application_name", "gonmap"} {
+		body = append(append(body, value...), 0)
+	}
+	body = append(body, 0)
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(body)+4)), body...)
+}
+
+// postgresErrorFields 解析 ErrorResponse 中的字段 type(1) value\0
+func postgresErrorFields(body []byte) map[byte]string {
+	fields := make(map[byte]string)
+	for len(body) > 1 && body[0] != 0 {
+		end := bytes.IndexByte(body[1:], 0)
+		if end < 0 {
+			break
+		}
+		fields[body[0]] = string(body[1 : 1+end])
+		body = body[2+end:]
+	}
+	return fields
+}
+
+// cStrings 解析以空字符串结尾的 C 字符串列表
+func cStrings(data []byte) []string {
+	var values []string
+	for _, value := range strings.Split(string(data), "\x00") {
+		if value == "" {
+			break
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+func init() {
+	mustRegisterDetector(postgresDetector{})
+}
@@ -9,7 +9,6 @@ import (
 	"strconv"
 	"strings"
 	"time"
-	"unicode"
 )
 
 type Protocol string
@@ -22,51 +21,74 @@ const (
 //go:embed nmap-service-probes
 var probes string
 
-var probeExprRegx = regexp.MustCompile("^(UDP|TCP) ([a-zA-Z0-9-_./]+) (?:q\\|([^|]*)\\|)")
+var probeNameRegx = regexp.MustCompile(`^[a-zA-Z0-9-_./]+$`)
 var probeIntRegx = regexp.MustCompile(`^(\d+)$`)
 
-// Helper function to convert a hexadecimal string to a byte
-func hexToByte(hex string) (byte, error) {
-	b, err := strconv.ParseInt(hex, 16, 0)
-	if err != nil {
-		return 0, err
+// buildString 解析探针数据中的转义字符 支持 \0 \a \b \f \n \r \t \v \xHH 以及 \\ \|
+func buildString(str string) string {
+	var builder strings.Builder
+	for i := 0; i < len(str); i++ {
+		c := str[i]
+		if c != '\\' || i == len(str)-1 {
+			builder.WriteByte(c)
+			continue
+		}
+		i++
+		switch str[i] {
+		case '0':
+			builder.WriteByte(0)
+		case 'a':
+			builder.WriteByte('\a')
+		case 'b':
+			builder.WriteByte('\b')
+		case 'f':
+			builder.WriteByte('\f')
+		case 'n':
+			builder.WriteByte('\n')
+		case 'r':
+			builder.WriteByte('\r')
+		case 't':
+			builder.WriteByte('\t')
+		case 'v':
+			builder.WriteByte('\v')
+		case 'x':
+			if i+2 < len(str) {
+				if b, err := strconv.ParseUint(str[i+1:i+3], 16, 8); err == nil {
+					builder.WriteByte(byte(b))
+					i += 2
+					continue
+				}
+			}
+			builder.WriteString(`\x`)
+		default:
+			builder.WriteByte(str[i])
+		}
 	}
-	return byte(b), nil
+	return builder.String()
 }
 
-func buildString(str string) string {
-	// 转移\\0  为\0
-	str = strings.ReplaceAll(str, `\0`, string(byte(0)))
-	re := regexp.MustCompile(`\\x([0-9a-fA-F]{2})`)
-	result := re.ReplaceAllStringFunc(str, func(match string) string {
-		// Extract the hexadecimal value from the matched \x sequence
-		hexValue := match[2:]
-		// Parse the hexadecimal value and convert it to a byte
-		b, err := hexToByte(hexValue)
-		if err == nil {
-			return string([]byte{b})
-		} else {
-			return match
-		}
-	})
-	result = strings.ReplaceAll(result, "\\r", "\r")
-	result = strings.ReplaceAll(result, "\\n", "\n")
-	return result
+// probeDirectives 探针文件中的全部指令
+var probeDirectives = []string{
+	"Exclude", "Probe", "match", "softmatch", "ports", "sslports", "totalwaitms", "tcpwrappedms", "rarity", "fallback",
 }
 
-func isCommand(line string) bool {
-	// 判断是否为探针指令
-	if len(line) < 2 {
-		return false
-	}
-	if line[:1] == "#" {
-		return false
+// splitCommand 拆分指令名和参数 注释和空行返回空指令名
+func splitCommand(line string) (string, string) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[:1] == "#" {
+		return "", ""
 	}
-	commandName := line[:strings.Index(line, " ")]
-	commandArr := []string{
-		"Exclude", "Probe", "match", "softmatch", "ports", "sslports", "totalwaitms", "tcpwrappedms", "rarity", "fallback",
+	i := strings.IndexAny(line, " \t")
+	if i < 0 {
+		return line, ""
 	}
-	for _, item := range commandArr {
+	return line[:i], strings.TrimSpace(line[i+1:])
+}
+
+func isCommand(line string) bool {
+	// 判断是否为探针指令
+	commandName, _ := splitCommand(line)
+	for _, item := range probeDirectives {
 		if item == commandName {
 			return true
 		}
@@ -98,128 +120,185 @@ type probe struct {
 	sendRaw string
 	// 包含的所有服务 ，用于优先匹配
 	services map[string]struct{}
+	// 是否声明了 rarity 未声明时按 defaultRarity 处理
+	hasRarity bool
+	// no-payload 只影响端口扫描的 UDP 负载 版本扫描照常发送 这里只记录
+	noPayload bool
+	// source=<port> 发送探针时使用的本地源端口
+	sourcePort int
+	// 无法识别的 Probe 选项 加载时作为警告报告
+	unknownFlags []string
+	// 不为空时探针由 Go 代码实现 不发送 sendRaw 也没有 match 规则
+	detector Detector
 }
 
+func newProbe() *probe {
+	return &probe{services: map[string]struct{}{}, matchGroup: make([]*match, 0)}
+}
+
+// match 依次匹配探针自身的指纹 没有匹配时按 fallback 指令使用其他探针的指纹
 func (p *probe) match(banner []byte) *MatchResult {
+	if result := p.matchRules(banner); result != nil {
+		return result
+	}
+	for _, fb := range p.fallbackProbe {
+		if result := fb.matchRules(banner); result != nil {
+			result.Probe = p.Name
+			result.Fallback = true
+			return result
+		}
+	}
+	return nil
+}
+
+func (p *probe) matchRules(banner []byte) *MatchResult {
+	input := bytesToRunes(banner)
 	for _, m := range p.matchGroup {
-		matcher, err := m.regex.FindStringMatch(string(banner))
+		matcher, err := m.regex.FindRunesMatch(input)
 		if err != nil {
 			continue
 		}
 		if matcher == nil {
 			continue
 		}
-		if len(matcher.Groups()) == 0 {
-			continue
+		var groups [][]byte
+		for _, group := range matcher.Groups() {
+			groups = append(groups, runesToBytes(group.Runes()))
 		}
-		var result = &MatchResult{Response: banner, Service: m.service, match: m}
-		var groups = map[string]string{}
-		if len(matcher.Groups()) > 1 {
-			for index, group := range matcher.Groups() {
-				groups[fmt.Sprintf("$%d", index)] = group.String()
-			}
-		}
-		if m.versionMate.Version != "" {
-			result.Version = groups[m.versionMate.Version]
+		vm := m.versionMate
+		var result = &MatchResult{
+			Response:   banner,
+			Service:    m.service,
+			Product:    expandTemplate(vm.ProductName, groups),
+			Version:    expandTemplate(vm.Version, groups),
+			Info:       expandTemplate(vm.Info, groups),
+			Hostname:   expandTemplate(vm.Hostname, groups),
+			OS:         expandTemplate(vm.OperatingSystem, groups),
+			DeviceType: expandTemplate(vm.DeviceType, groups),
+			Probe:      p.Name,
+			Soft:       m.soft,
+			match:      m,
 		}
-		if m.versionMate.ProductName != "" {
-			result.Product = groups[m.versionMate.ProductName]
+		for _, cpe := range vm.CPE {
+			result.CPE = append(result.CPE, expandTemplate(cpe, groups))
 		}
 		return result
 	}
 	return nil
 }
 
-func (p *probe) loadLine(s string, index int) {
-	//分解命令
-	index += 1
-	i := strings.Index(s, " ")
-	commandName := s[:i]
-	commandArgs := s[i+1:]
-	commandArgs = strings.TrimSpace(commandArgs)
+func (p *probe) loadLine(commandName, commandArgs string, line int) error {
 	//逐行处理
 	switch commandName {
 	case "Probe":
-		p.loadProbe(commandArgs)
+		return p.loadProbe(commandArgs)
 	case "match":
-		p.loadMatch(commandArgs, false, index)
+		return p.loadMatch(commandArgs, false, line)
 	case "softmatch":
-		p.loadMatch(commandArgs, true, index)
+		return p.loadMatch(commandArgs, true, line)
 	case "ports":
-		p.loadPorts(commandArgs, false)
+		return p.loadPorts(commandArgs, false)
 	case "sslports":
-		p.loadPorts(commandArgs, true)
+		return p.loadPorts(commandArgs, true)
 	case "totalwaitms":
-		p.totalWaiTms = time.Duration(p.getInt(commandArgs)) * time.Millisecond
+		v, err := p.getInt(commandArgs)
+		p.totalWaiTms = time.Duration(v) * time.Millisecond
+		return err
 	case "tcpwrappedms":
-		p.tcpwrappedms = time.Duration(p.getInt(commandArgs)) * time.Millisecond
+		v, err := p.getInt(commandArgs)
+		p.tcpwrappedms = time.Duration(v) * time.Millisecond
+		return err
 	case "rarity":
-		p.rarity = p.getInt(commandArgs)
+		v, err := p.getInt(commandArgs)
+		if err == nil && (v < 1 || v > 9) {
+			err = fmt.Errorf("rarity 必须在 1-9 之间: %d", v)
+		}
+		p.rarity = v
+		p.hasRarity = true
+		return err
 	case "fallback":
 		p.fallback = p.getString(commandArgs)
 	}
+	return nil
 }
 
-func (p *probe) loadProbe(s string) {
-	if !probeExprRegx.MatchString(s) {
-		panic(errors.New("probe 语句格式不正确:" + s))
+// loadProbe 解析 Probe <TCP|UDP> <name> q<d><payload><d> [no-payload]
+func (p *probe) loadProbe(s string) error {
+	args := strings.SplitN(s, " ", 3)
+	if len(args) != 3 || !strings.HasPrefix(args[2], "q") {
+		return errors.New("probe 语句格式不正确:" + s)
 	}
-	args := probeExprRegx.FindStringSubmatch(s)
-	if args[1] == "" || args[2] == "" {
-		panic(errors.New("probe 参数格式不正确"))
-	}
-	if args[1] == string(TCP) {
+	if args[0] == string(TCP) {
 		p.protocol = TCP
-	} else if args[1] == string(UDP) {
+	} else if args[0] == string(UDP) {
 		p.protocol = UDP
 	} else {
-		panic(errors.New(fmt.Sprintf("probe 参数格式不正确(%v)", args)))
+		return fmt.Errorf("probe 参数格式不正确(%v)", args)
+	}
+	if !probeNameRegx.MatchString(args[1]) {
+		return errors.New("probe 名称不正确:" + args[1])
+	}
+	p.Name = args[1]
+	payload, rest, err := readDelimited(args[2][1:])
+	if err != nil {
+		return fmt.Errorf("probe 语句格式不正确: %w", err)
+	}
+	p.sendRaw = buildString(payload)
+	for _, flag := range strings.Fields(rest) {
+		switch {
+		case flag == "no-payload":
+			p.noPayload = true
+		case strings.HasPrefix(flag, "source="):
+			port, err := strconv.Atoi(flag[len("source="):])
+			if err != nil || port < 1 || port > 65535 {
+				return errors.New("probe source 端口不正确: " + flag)
+			}
+			p.sourcePort = port
+		default:
+			p.unknownFlags = append(p.unknownFlags, flag)
+		}
 	}
-	p.Name = args[2]
-	str := args[3]
-	p.sendRaw = buildString(str)
+	return nil
 }
 
-func (p *probe) loadMatch(s string, soft bool, index int) {
-	m := parseMatch(s, soft)
-	m.line = index
+func (p *probe) loadMatch(s string, soft bool, line int) error {
+	m, err := parseMatch(s, soft)
+	if err != nil {
+		return err
+	}
+	m.line = line
 	p.matchGroup = append(p.matchGroup, m)
 	p.services[m.service] = struct{}{}
+	return nil
 }
 
-func (p *probe) loadPorts(expr string, ssl bool) {
+func (p *probe) loadPorts(expr string, ssl bool) error {
+	ports, err := parsePortSpec(expr, p.protocol)
+	if err != nil {
+		return err
+	}
 	if ssl {
-		p.sslports = parsePortList(expr)
+		p.sslports = ports[p.protocol]
 	} else {
-		p.ports = parsePortList(expr)
+		p.ports = ports[p.protocol]
 	}
+	return nil
 }
 
-func (p *probe) getInt(expr string) int {
+func (p *probe) getInt(expr string) (int, error) {
 	if !probeIntRegx.MatchString(expr) {
-		panic(errors.New("totalwaitms or tcpwrappedms 语句参数不正确"))
+		return 0, errors.New("数值参数不正确: " + expr)
 	}
 	i, _ := strconv.Atoi(probeIntRegx.FindStringSubmatch(expr)[1])
-	return i
+	return i, nil
 }
 
 func (p *probe) getString(expr string) []string {
 	var pbs []string
-	fb := ""
-
-	for _, pb := range expr {
-		if unicode.IsDigit(pb) || unicode.IsLetter(pb) {
-			fb += string(pb)
-			continue
+	for _, pb := range strings.Split(expr, ",") {
+		if pb = strings.TrimSpace(pb); pb != "" {
+			pbs = append(pbs, pb)
 		}
-		if pb == ',' {
-			pbs = append(pbs, fb)
-			fb = ""
-			continue
-		}
-	}
-	if fb != "" {
-		pbs = append(pbs, fb)
 	}
 	return pbs
 }
@@ -232,18 +311,64 @@ func (p *probe) isNullProbe() bool {
 	return p.Name == "NULL"
 }
 
+// defaultRarity 未声明 rarity 的探针按 nmap 的默认值处理
+const defaultRarity = 5
+
+// effectiveRarity 未声明 rarity 时使用默认值 NULL 探针始终为 0
+func (p *probe) effectiveRarity() int {
+	if p.isNullProbe() {
+		return 0
+	}
+	if !p.hasRarity {
+		return defaultRarity
+	}
+	return p.rarity
+}
+
+// selectProbes 按照版本探测强度挑选探针
+// 与 nmap 一致 NULL 探针始终发送 声明了当前端口的探针不受强度限制 其余探针要求 rarity 不超过强度
+func selectProbes(probes []*probe, port int, ssl bool, intensity int) []*probe {
+	var selected []*probe
+	for _, pb := range probes {
+		if pb.isNullProbe() || pb.effectiveRarity() <= intensity ||
+			(!ssl && pb.ports.exist(port)) || (ssl && pb.sslports.exist(port)) {
+			selected = append(selected, pb)
+		}
+	}
+	return selected
+}
+
 var portRangeRegx = regexp.MustCompile("^(\\d+)(?:-(\\d+))?$")
-var portGroupRegx = regexp.MustCompile("^(\\d+(?:-\\d+)?)(?:,\\d+(?:-\\d+)?)*$")
 
 type PortList []int
 
-func parsePortList(express string) PortList {
-	var list = PortList([]int{})
-	if portGroupRegx.MatchString(express) == false {
-		panic("port expression string invalid")
+// parsePortSpec 解析 nmap 端口表达式 例如 T:80,443,U:53,1000-2000
+// T: U: 前缀作用于其后的所有端口 没有前缀的端口属于 defaultProtocol 为空时同时属于 TCP 和 UDP
+func parsePortSpec(express string, defaultProtocol Protocol) (map[Protocol]PortList, error) {
+	var result = map[Protocol]PortList{}
+	var protocols []Protocol
+	if defaultProtocol == "" {
+		protocols = []Protocol{TCP, UDP}
+	} else {
+		protocols = []Protocol{defaultProtocol}
 	}
 	for _, expr := range strings.Split(express, ",") {
+		expr = strings.TrimSpace(expr)
+		if len(expr) > 2 && expr[1] == ':' {
+			switch expr[:2] {
+			case "T:":
+				protocols = []Protocol{TCP}
+			case "U:":
+				protocols = []Protocol{UDP}
+			default:
+				return nil, errors.New("port expression string invalid: " + express)
+			}
+			expr = expr[2:]
+		}
 		rArr := portRangeRegx.FindStringSubmatch(expr)
+		if rArr == nil {
+			return nil, errors.New("port expression string invalid: " + express)
+		}
 		var startPort, endPort int
 		startPort, _ = strconv.Atoi(rArr[1])
 		if rArr[2] != "" {
@@ -251,12 +376,19 @@ func parsePortList(express string) PortList {
 		} else {
 			endPort = startPort
 		}
-		for num := startPort; num <= endPort; num++ {
-			list = append(list, num)
+		if endPort > 65535 || startPort > endPort {
+			return nil, errors.New("port expression string invalid: " + express)
 		}
+		for _, protocol := range protocols {
+			for num := startPort; num <= endPort; num++ {
+				result[protocol] = append(result[protocol], num)
+			}
+		}
+	}
+	for protocol, list := range result {
+		result[protocol] = list.removeDuplicate()
 	}
-	list = list.removeDuplicate()
-	return list
+	return result, nil
 }
 
 func (p PortList) removeDuplicate() PortList {
@@ -280,32 +412,87 @@ func (p PortList) exist(port int) bool {
 	return false
 }
 
+// probeFile 解析后的探针文件
+type probeFile struct {
+	probes  []*probe
+	exclude map[Protocol]PortList
+	// 未知指令等不影响加载的问题
+	warnings []string
+}
+
+// LoadProbes 解析探针文件 versionIntensity 小于 9 时只保留 rarity 不超过 versionIntensity 的探针 NULL 探针始终保留
+// 格式错误时 panic 需要错误返回值时使用 parseProbeFile
+// Nmap 加载全部探针 在每次扫描时按强度挑选 见 selectProbes
 func LoadProbes(s string, versionIntensity int) []*probe {
+	file, err := parseProbeFile(s)
+	if err != nil {
+		panic(err)
+	}
+	if versionIntensity >= 9 {
+		return file.probes
+	}
+	var probeList []*probe
+	for _, pb := range file.probes {
+		if pb.isNullProbe() || pb.effectiveRarity() <= versionIntensity {
+			probeList = append(probeList, pb)
+		}
+	}
+	return probeList
+}
+
+func parseProbeFile(s string) (*probeFile, error) {
 	scanner := bufio.NewScanner(strings.NewReader(s))
-	var pb = &probe{services: map[string]struct{}{}, matchGroup: make([]*match, 0)}
-	var probeList = make([]*probe, 0)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	file := &probeFile{exclude: map[Protocol]PortList{}}
+	var pb *probe
 	lineIndex := 0
+	appendProbe := func() {
+		if pb != nil && len(pb.matchGroup) > 0 {
+			file.probes = append(file.probes, pb)
+		}
+	}
 	for scanner.Scan() {
-		line := scanner.Text()
-		if !isCommand(line) {
+		lineIndex++
+		commandName, commandArgs := splitCommand(scanner.Text())
+		if commandName == "" {
+			continue
+		}
+		if !isCommand(commandName) {
+			file.warnings = append(file.warnings, fmt.Sprintf("line %d: unknown directive %s", lineIndex, commandName))
 			continue
 		}
-		commandName := line[:strings.Index(line, " ")]
+		switch commandName {
+		case "Exclude":
+			ports, err := parsePortSpec(commandArgs, "")
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineIndex, err)
+			}
+			for protocol, list := range ports {
+				file.exclude[protocol] = append(file.exclude[protocol], list...).removeDuplicate()
+			}
+			continue
+		case "Probe":
+			appendProbe()
+			pb = newProbe()
+		default:
+			if pb == nil {
+				return nil, fmt.Errorf("line %d: %s before the first Probe", lineIndex, commandName)
+			}
+		}
+		if err := pb.loadLine(commandName, commandArgs, lineIndex); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineIndex, err)
+		}
 		if commandName == "Probe" {
-			if len(pb.matchGroup) > 0 {
-				if pb.rarity <= versionIntensity || versionIntensity == 9 {
-					probeList = append(probeList, pb)
-				}
-				pb = &probe{services: map[string]struct{}{}, matchGroup: make([]*match, 0)}
+			for _, flag := range pb.unknownFlags {
+				file.warnings = append(file.warnings, fmt.Sprintf("line %d: unknown probe option %s", lineIndex, flag))
 			}
 		}
-		pb.loadLine(line, lineIndex)
-		lineIndex++
 	}
-	if len(pb.matchGroup) > 0 {
-		probeList = append(probeList, pb)
+	if err := scanner.Err(); err != nil {
+		return nil, err
 	}
-	return probeList
+	appendProbe()
+	return file, nil
 }
 
 func sortProbes(probes []*probe, port int, ssl bool) []*probe {
@@ -313,18 +500,57 @@ func sortProbes(probes []*probe, port int, ssl bool) []*probe {
 	/*
 		总共的探针不超过100 所以这里直接遍历 不需要考虑性能
 	*/
+	var detectors []*probe
 	var probesSorts []*probe
 	var others []*probe
 	for _, pb := range probes {
 		if (pb.ports.exist(port) && !ssl) || (pb.sslports.exist(port) && ssl) {
-			probesSorts = append(probesSorts, pb)
+			// 端口匹配的检测器排在探针之前 否则 mqtt 等探针先得到只有服务名的硬匹配
+			// 检测器不会再发送 也就得不到检测器中的版本和结构化信息
+			if pb.detector != nil {
+				detectors = append(detectors, pb)
+			} else {
+				probesSorts = append(probesSorts, pb)
+			}
 			continue
 		}
 		others = append(others, pb)
 	}
-	probesSorts = append(probesSorts, others...)
+	probesSorts = append(detectors, append(probesSorts, others...)...)
 	return probesSorts
 }
+
+// budgetSort 限制探针数量时的发送顺序 NULL 探针在前 其次是最适合该端口的探针
+// PortRequest 中指定的探针优先 否则取端口匹配的探针中 rarity 最小的
+func budgetSort(probes []*probe, port int, ssl bool) []*probe {
+	var null, best *probe
+	for _, pb := range probes {
+		if pb.isNullProbe() {
+			null = pb
+			continue
+		}
+		if !(pb.ports.exist(port) && !ssl) && !(pb.sslports.exist(port) && ssl) {
+			continue
+		}
+		if best == nil || pb.Name == PortRequest[port] ||
+			(best.Name != PortRequest[port] && pb.effectiveRarity() < best.effectiveRarity()) {
+			best = pb
+		}
+	}
+	var result []*probe
+	for _, pb := range []*probe{null, best} {
+		if pb != nil {
+			result = append(result, pb)
+		}
+	}
+	for _, pb := range probes {
+		if pb != null && pb != best {
+			result = append(result, pb)
+		}
+	}
+	return result
+}
+
 func perfSort(port int, ps []*probe) []*probe {
 	if pn, ok := PortRequest[port]; ok {
 		for i, pb := range ps {
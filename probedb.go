@@ -0,0 +1,184 @@
+package gonmap
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// probeDB 加载完成的探针库 加载后只读 重新加载时整体替换
+type probeDB struct {
+	tcpProbes []*probe
+	udpProbes []*probe
+	// Exclude 指令排除的端口
+	exclude map[Protocol]PortList
+	// 加载过程中的警告 例如未知指令
+	warnings []string
+}
+
+func newProbeDB(probeList []*probe) *probeDB {
+	db := &probeDB{exclude: map[Protocol]PortList{}}
+	for _, p := range probeList {
+		if p.protocol == TCP {
+			db.tcpProbes = append(db.tcpProbes, p)
+		} else {
+			db.udpProbes = append(db.udpProbes, p)
+		}
+	}
+	setFallback(db.tcpProbes)
+	setFallback(db.udpProbes)
+	return db
+}
+
+func (db *probeDB) isExcluded(protocol Protocol, port int) bool {
+	return db.exclude[protocol].exist(port)
+}
+
+func (db *probeDB) probes(protocol Protocol) []*probe {
+	if protocol == TCP {
+		return db.tcpProbes
+	}
+	return db.udpProbes
+}
+
+// loadProbeDB 按顺序加载探针源 基础探针库为内置文件或 ServiceProbes 指定的文件
+// ProbeSources 中的文件或目录依次叠加在基础探针库之上 探针全部加载 扫描时再按强度挑选
+func loadProbeDB(option *Options) (*probeDB, error) {
+	data := probes
+	if option.ServiceProbes != "" {
+		raw, err := os.ReadFile(option.ServiceProbes)
+		if err != nil {
+			return nil, err
+		}
+		data = string(raw)
+	}
+	file, err := parseProbeFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("load %s: %w", probeSourceName(option.ServiceProbes), err)
+	}
+	probeList := file.probes
+	exclude := file.exclude
+	var warnings []string
+	for _, warning := range file.warnings {
+		warnings = append(warnings, probeSourceName(option.ServiceProbes)+" "+warning)
+	}
+	for _, source := range option.ProbeSources {
+		files, err := probeSourceFiles(source)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range files {
+			raw, err := os.ReadFile(name)
+			if err != nil {
+				return nil, err
+			}
+			extra, err := parseProbeFile(string(raw))
+			if err != nil {
+				return nil, fmt.Errorf("load %s: %w", name, err)
+			}
+			probeList = mergeProbes(probeList, extra.probes)
+			for protocol, list := range extra.exclude {
+				exclude[protocol] = append(exclude[protocol], list...).removeDuplicate()
+			}
+			for _, warning := range extra.warnings {
+				warnings = append(warnings, name+" "+warning)
+			}
+		}
+	}
+	db := newProbeDB(probeList)
+	db.exclude = exclude
+	db.warnings = warnings
+	if !option.DisableDetectors {
+		db.addDetectors(Detectors())
+	}
+	return db, nil
+}
+
+func probeSourceName(path string) string {
+	if path == "" {
+		return "built-in nmap-service-probes"
+	}
+	return path
+}
+
+// probeSourceFiles 目录按文件名顺序加载其中的文件 忽略隐藏文件和子目录
+func probeSourceFiles(source string) ([]string, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{source}, nil
+	}
+	entries, err := os.ReadDir(source)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		files = append(files, filepath.Join(source, entry.Name()))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// mergeProbes 合并探针 同协议同名的探针追加指纹并合并端口 其余作为新探针加入
+func mergeProbes(base []*probe, extra []*probe) []*probe {
+	index := make(map[string]*probe, len(base))
+	for _, p := range base {
+		index[string(p.protocol)+"/"+p.Name] = p
+	}
+	for _, p := range extra {
+		exist, ok := index[string(p.protocol)+"/"+p.Name]
+		if !ok {
+			base = append(base, p)
+			index[string(p.protocol)+"/"+p.Name] = p
+			continue
+		}
+		exist.matchGroup = append(exist.matchGroup, p.matchGroup...)
+		if p.hasRarity {
+			exist.rarity, exist.hasRarity = p.rarity, true
+		}
+		for service := range p.services {
+			exist.services[service] = struct{}{}
+		}
+		exist.ports = append(exist.ports, p.ports...).removeDuplicate()
+		exist.sslports = append(exist.sslports, p.sslports...).removeDuplicate()
+		for _, fb := range p.fallback {
+			if !containsString(exist.fallback, fb) {
+				exist.fallback = append(exist.fallback, fb)
+			}
+		}
+	}
+	return base
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func setFallback(ps []*probe) {
+	probeMap := make(map[string]*probe)
+	for _, p := range ps {
+		probeMap[p.Name] = p
+	}
+	for _, pb := range ps {
+		pb.fallbackProbe = nil
+		for _, fb := range pb.fallback {
+			if _, ok := probeMap[fb]; ok {
+				pb.fallbackProbe = append(pb.fallbackProbe, probeMap[fb])
+			}
+
+		}
+	}
+}
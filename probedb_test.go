@@ -0,0 +1,81 @@
+package gonmap
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const customProbes = `
+Probe TCP NULL q||
+match inhouse m|^INHOUSE-SVC (\d+)| p/InHouse/ v/$1/
+
+Probe TCP InHouseHello q|HELLO\r\n|
+rarity 1
+ports 9999
+match inhouse m|^WELCOME|
+`
+
+func TestProbeSourcesMerge(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "10-inhouse"), []byte(customProbes), 0644); err != nil {
+		t.Fatal(err)
+	}
+	n, err := NewNmap(&Options{VersionIntensity: 9, ProbeSources: []string{dir}})
+	assert.NoError(t, err)
+	base := LoadProbes(probes, 9)
+	assert.Equal(t, len(base)+1+len(Detectors()), len(n.GetTcpProbe())+len(n.GetUdpProbe()))
+
+	result := n.Match(TCP, []byte("INHOUSE-SVC 42\r\n"), "NULL")
+	if assert.NotNil(t, result) {
+		assert.Equal(t, "inhouse", result.Service)
+	}
+	// 加载失败时保留旧的探针库
+	n.option.ProbeSources = []string{filepath.Join(dir, "missing")}
+	assert.ErrorIs(t, n.Reload(), ErrProbeDB)
+	assert.NotNil(t, n.Match(TCP, []byte("WELCOME\r\n"), "InHouseHello"))
+}
+
+func TestRegisterProbe(t *testing.T) {
+	n, err := NewNmap(&Options{VersionIntensity: 7})
+	assert.NoError(t, err)
+	before := n.GetTcpProbe()
+
+	spec := ProbeSpec{
+		Name:     "InHousePing",
+		Protocol: TCP,
+		Payload:  []byte("PING\r\n"),
+		Ports:    []int{9999},
+		Rarity:   3,
+		Rules: []MatchSpec{
+			{Service: "inhouse", Pattern: `^PONG inhouse ([\d.]+)`, Product: "InHouse", Version: "$1", CPE: []string{"a:example:inhouse:$1"}},
+		},
+	}
+	assert.NoError(t, n.RegisterProbe(spec))
+	assert.Error(t, n.RegisterProbe(spec), "duplicate name")
+	assert.Len(t, before, len(n.GetTcpProbe())-1, "existing snapshot is not modified")
+
+	result := n.Match(TCP, []byte("PONG inhouse 1.2\r\n"), "InHousePing")
+	if assert.NotNil(t, result) {
+		assert.Equal(t, "1.2", result.Version)
+		assert.Equal(t, []string{"cpe:/a:example:inhouse:1.2"}, result.CPE)
+	}
+	pb, ok := n.FindProbe(TCP, "InHousePing")
+	assert.True(t, ok)
+	assert.Equal(t, 3, pb.Rarity())
+
+	assert.NoError(t, n.AddMatch(TCP, "NULL", MatchSpec{Service: "inhouse", Pattern: `^INHOUSE READY`, Soft: true}))
+	assert.Error(t, n.AddMatch(TCP, "Missing", MatchSpec{Service: "inhouse", Pattern: `^x`}))
+	assert.Error(t, n.AddMatch(TCP, "NULL", MatchSpec{Service: "inhouse", Pattern: `^(x`}))
+	assert.Error(t, n.RegisterProbe(ProbeSpec{Name: "Bad", Protocol: TCP, Rarity: 10, Rules: spec.Rules}))
+	assert.Error(t, n.RegisterProbe(ProbeSpec{Name: "NoRules", Protocol: TCP}))
+
+	// Reload 之后注册的探针和规则依然有效
+	assert.NoError(t, n.Reload())
+	_, ok = n.FindProbe(TCP, "InHousePing")
+	assert.True(t, ok)
+	null, _ := n.FindProbe(TCP, "NULL")
+	assert.NotNil(t, null.Rules()[len(null.Rules())-1].Match([]byte("INHOUSE READY\r\n")))
+}
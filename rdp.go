@@ -0,0 +1,258 @@
+package gonmap
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+)
+
+const (
+	// RDP_NEG_REQ requestedProtocols
+	rdpProtocolRDP    = 0
+	rdpProtocolSSL    = 1
+	rdpProtocolHybrid = 2
+	rdpProtocolRDSTLS = 4
+	// CredSSP TSRequest 的最大长度
+	maxTSRequest = 64 * 1024
+)
+
+var errTSRequest = errors.New("credssp response malformed")
+
+// rdpSecurityLayers 逐个测试的安全层 CredSSP 需要同时请求 TLS
+var rdpSecurityLayers = []struct {
+	name      string
+	requested uint32
+	selected  uint32
+}{
+	{"RDP", rdpProtocolRDP, rdpProtocolRDP},
+	{"TLS", rdpProtocolSSL, rdpProtocolSSL},
+	{"CredSSP", rdpProtocolSSL | rdpProtocolHybrid, rdpProtocolHybrid},
+	{"RDSTLS", rdpProtocolRDSTLS, rdpProtocolRDSTLS},
+}
+
+// RDPInfo RDP 服务支持的安全层以及 CredSSP 中的 NTLM 信息
+type RDPInfo struct {
+	// RDP TLS CredSSP RDSTLS
+	SecurityLayers []string `json:"security_layers"`
+	// 服务端支持 CredSSP 时从 NTLM CHALLENGE 中获取
+	NTLM *NTLMInfo `json:"ntlm,omitempty"`
+}
+
+// rdpNegotiation X.224 Connection Confirm 中的协商结果
+type rdpNegotiation struct {
+	// 服务端没有返回协商数据 只支持标准 RDP 安全层
+	legacy   bool
+	selected uint32
+	// RDP_NEG_FAILURE 以及失败码
+	failed      bool
+	failureCode uint32
+	response    []byte
+}
+
+// rdpDetector 发送 X.224 Connection Request 测试支持的安全层 支持 CredSSP 时获取 NTLM 信息
+type rdpDetector struct{}
+
+func (rdpDetector) Name() string       { return "RDPNegotiation" }
+func (rdpDetector) Protocol() Protocol { return TCP }
+func (rdpDetector) Ports() []int       { return []int{3389} }
+func (rdpDetector) Services() []string { return []string{"ms-wbt-server"} }
+func (rdpDetector) Rarity() int        { return 9 }
+
+func (rdpDetector) Detect(ctx context.Context, conn net.Conn, target Target) (*MatchResult, error) {
+	first := rdpSecurityLayers[2]
+	negotiation, err := rdpNegotiate(conn, first.requested)
+	if negotiation == nil {
+		return nil, err
+	}
+	result := &MatchResult{Service: "ms-wbt-server", Response: negotiation.response}
+	info := &RDPInfo{}
+	if negotiation.legacy {
+		info.SecurityLayers = []string{"RDP"}
+	} else {
+		for _, layer := range rdpSecurityLayers {
+			if layer.requested == first.requested {
+				if !negotiation.failed && negotiation.selected == layer.selected {
+					info.SecurityLayers = append(info.SecurityLayers, layer.name)
+				}
+				continue
+			}
+			if target.Dial == nil || ctx.Err() != nil {
+				continue
+			}
+			if rdpSupports(ctx, target, layer.requested, layer.selected) {
+				info.SecurityLayers = append(info.SecurityLayers, layer.name)
+			}
+		}
+	}
+	if !negotiation.failed && negotiation.selected == rdpProtocolHybrid {
+		ntlm, err := credSSPChallenge(ctx, conn, target.ServerName)
+		if err != nil && ntlm == nil {
+			return rdpResult(result, info), err
+		}
+		info.NTLM = ntlm
+	}
+	return rdpResult(result, info), nil
+}
+
+// rdpResult 根据安全层和 NTLM 信息填写识别结果
+func rdpResult(result *MatchResult, info *RDPInfo) *MatchResult {
+	if len(info.SecurityLayers) > 0 {
+		result.Info = "security: " + strings.Join(info.SecurityLayers, ", ")
+	}
+	if info.NTLM != nil {
+		result.Product = "Microsoft Terminal Services"
+		result.Version = info.NTLM.OSVersion
+		result.Hostname = info.NTLM.NetBIOSComputerName
+		result.OS = "Windows"
+		result.CPE = []string{"cpe:/o:microsoft:windows"}
+	}
+	result.Details = info
+	return result
+}
+
+// rdpSupports 使用新连接请求一种安全层 服务端选择该安全层时返回 true
+func rdpSupports(ctx context.Context, target Target, requested, selected uint32) bool {
+	conn, err := target.Dial(ctx)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	negotiation, _ := rdpNegotiate(conn, requested)
+	if negotiation == nil || negotiation.failed {
+		return false
+	}
+	if negotiation.legacy {
+		return selected == rdpProtocolRDP
+	}
+	return negotiation.selected == selected
+}
+
+// rdpConnectionRequest TPKT + X.224 Connection Request + RDP_NEG_REQ
+func rdpConnectionRequest(requested uint32) []byte {
+	packet := []byte{
+		0x03, 0x00, 0x00, 0x13,
+		0x0e, 0xe0, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x01, 0x00, 0x08, 0x00,
+	}
+	return binary.LittleEndian.AppendUint32(packet, requested)
+}
+
+// rdpNegotiate 发送 Connection Request 并解析 Connection Confirm 响应不是 Connection Confirm 时返回 nil
+func rdpNegotiate(conn net.Conn, requested uint32) (*rdpNegotiation, error) {
+	if _, err := conn.Write(rdpConnectionRequest(requested)); err != nil {
+		return nil, err
+	}
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	length := int(binary.BigEndian.Uint16(header[2:]))
+	if header[0] != 0x03 || length < 11 || length > 512 {
+		return nil, nil
+	}
+	body := make([]byte, length-4)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return nil, err
+	}
+	// LI(1) CC(1) DST-REF(2) SRC-REF(2) CLASS(1)
+	if body[1]&0xf0 != 0xd0 {
+		return nil, nil
+	}
+	negotiation := &rdpNegotiation{response: append(header, body...)}
+	// type(1) flags(1) length(2) value(4)
+	if len(body) < 15 {
+		negotiation.legacy = true
+		return negotiation, nil
+	}
+	value := binary.LittleEndian.Uint32(body[11:])
+	switch body[7] {
+	case 0x02:
+		negotiation.selected = value
+	case 0x03:
+		negotiation.failed = true
+		negotiation.failureCode = value
+	default:
+		negotiation.legacy = true
+	}
+	return negotiation, nil
+}
+
+// credSSPChallenge 在协商 CredSSP 后的连接上完成 TLS 握手 发送 NTLM NEGOTIATE 并解析 CHALLENGE
+func credSSPChallenge(ctx context.Context, conn net.Conn, serverName string) (*NTLMInfo, error) {
+	tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true, ServerName: serverName})
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+	if _, err := tlsConn.Write(tsRequest(ntlmNegotiate)); err != nil {
+		return nil, err
+	}
+	data, err := readDER(tlsConn, maxTSRequest)
+	if err != nil {
+		return nil, err
+	}
+	return parseNTLMChallenge(data)
+}
+
+// tsRequest CredSSP TSRequest version 6 negoTokens 中只有一个 token
+func tsRequest(token []byte) []byte {
+	negoToken := derTLV(0x30, derTLV(0xa0, derTLV(0x04, token)))
+	version := derTLV(0xa0, derTLV(0x02, []byte{0x06}))
+	return derTLV(0x30, append(version, derTLV(0xa1, derTLV(0x30, negoToken))...))
+}
+
+// derTLV 按 DER 编码一个 tag-length-value
+func derTLV(tag byte, value []byte) []byte {
+	data := []byte{tag}
+	switch n := len(value); {
+	case n < 0x80:
+		data = append(data, byte(n))
+	case n < 0x100:
+		data = append(data, 0x81, byte(n))
+	default:
+		data = append(data, 0x82, byte(n>>8), byte(n))
+	}
+	return append(data, value...)
+}
+
+// readDER 读取一个完整的 DER 元素 长度超过 limit 时返回错误
+func readDER(r io.Reader, limit int) ([]byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	length := int(header[1])
+	if length&0x80 != 0 {
+		size := length & 0x7f
+		if size == 0 || size > 3 {
+			return nil, errTSRequest
+		}
+		extra := make([]byte, size)
+		if _, err := io.ReadFull(r, extra); err != nil {
+			return nil, err
+		}
+		header = append(header, extra...)
+		length = 0
+		for _, b := range extra {
+			length = length<<8 | int(b)
+		}
+	}
+	if length > limit {
+		return nil, errTSRequest
+	}
+	value := make([]byte, length)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return nil, err
+	}
+	return append(header, value...), nil
+}
+
+func init() {
+	mustRegisterDetector(rdpDetector{})
+}
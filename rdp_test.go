@@ -0,0 +1,181 @@
+package gonmap
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// ntlmChallengeMessage 构造 CHALLENGE_MESSAGE pairs 的键为 AV_PAIR id
+func ntlmChallengeMessage(pairs map[uint16]string, major, minor byte, build uint16) []byte {
+	var info []byte
+	for id := uint16(1); id <= 5; id++ {
+		value, ok := pairs[id]
+		if !ok {
+			continue
+		}
+		encoded := utf16.Encode([]rune(value))
+		info = binary.LittleEndian.AppendUint16(info, id)
+		info = binary.LittleEndian.AppendUint16(info, uint16(len(encoded)*2))
+		for _, unit := range encoded {
+			info = binary.LittleEndian.AppendUint16(info, unit)
+		}
+	}
+	info = append(info, 0, 0, 0, 0)
+	msg := append([]byte(nil), ntlmSignature...)
+	msg = binary.LittleEndian.AppendUint32(msg, 2)
+	// TargetName 为空
+	msg = append(msg, 0, 0, 0, 0, 56, 0, 0, 0)
+	msg = binary.LittleEndian.AppendUint32(msg, 0xe28a8215)
+	msg = append(msg, make([]byte, 16)...)
+	msg = binary.LittleEndian.AppendUint16(msg, uint16(len(info)))
+	msg = binary.LittleEndian.AppendUint16(msg, uint16(len(info)))
+	msg = binary.LittleEndian.AppendUint32(msg, 56)
+	msg = append(msg, major, minor)
+	msg = binary.LittleEndian.AppendUint16(msg, build)
+	msg = append(msg, 0, 0, 0, 0x0f)
+	return append(msg, info...)
+}
+
+func testCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "WIN-TEST"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// serveRDP 模拟要求 NLA 的 RDP 服务 支持 TLS 和 CredSSP
+func serveRDP(t *testing.T, challenge []byte) int {
+	config := &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	confirm := func(negType byte, value uint32) []byte {
+		packet := []byte{0x03, 0x00, 0x00, 0x13, 0x0e, 0xd0, 0x00, 0x00, 0x12, 0x34, 0x00, negType, 0x00, 0x08, 0x00}
+		return binary.LittleEndian.AppendUint32(packet, value)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+				request := make([]byte, 19)
+				if _, err := io.ReadFull(conn, request); err != nil {
+					return
+				}
+				requested := binary.LittleEndian.Uint32(request[15:])
+				switch {
+				case requested&rdpProtocolHybrid != 0:
+					_, _ = conn.Write(confirm(0x02, rdpProtocolHybrid))
+				case requested&rdpProtocolSSL != 0:
+					_, _ = conn.Write(confirm(0x02, rdpProtocolSSL))
+					return
+				default:
+					// HYBRID_REQUIRED_BY_SERVER
+					_, _ = conn.Write(confirm(0x03, 0x05))
+					return
+				}
+				tlsConn := tls.Server(conn, config)
+				if _, err := readDER(tlsConn, maxTSRequest); err != nil {
+					return
+				}
+				_, _ = tlsConn.Write(derTLV(0x30, derTLV(0xa1, derTLV(0x04, challenge))))
+			}()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestNTLMChallenge(t *testing.T) {
+	msg := ntlmChallengeMessage(map[uint16]string{
+		1: "WIN-TEST",
+		2: "CORP",
+		3: "win-test.corp.local",
+		4: "corp.local",
+		5: "corp.local",
+	}, 10, 0, 17763)
+	info, err := parseNTLMChallenge(append([]byte{0x30, 0x82}, msg...))
+	assert.NoError(t, err)
+	if assert.NotNil(t, info) {
+		assert.Equal(t, "WIN-TEST", info.NetBIOSComputerName)
+		assert.Equal(t, "CORP", info.NetBIOSDomainName)
+		assert.Equal(t, "win-test.corp.local", info.DNSComputerName)
+		assert.Equal(t, "corp.local", info.DNSDomainName)
+		assert.Equal(t, "corp.local", info.DNSTreeName)
+		assert.Equal(t, "10.0.17763", info.OSVersion)
+	}
+	_, err = parseNTLMChallenge([]byte("NTLMSSP\x00\x01\x00\x00\x00"))
+	assert.Error(t, err)
+	_, err = parseNTLMChallenge(msg[:60])
+	assert.Error(t, err)
+}
+
+func TestFileTime(t *testing.T) {
+	assert.Nil(t, fileTime(0))
+	// 早于 1970 年的时间不能下溢
+	assert.Nil(t, fileTime(1))
+	now := time.Unix(1700000000, 123456700).UTC()
+	ft := uint64(now.UnixNano()/100) + 116444736000000000
+	if assert.NotNil(t, fileTime(ft)) {
+		assert.True(t, now.Equal(*fileTime(ft)))
+	}
+	data, err := json.Marshal(&NTLMInfo{NetBIOSDomainName: "CORP"})
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "system_time")
+}
+
+func TestRDPDetector(t *testing.T) {
+	challenge := ntlmChallengeMessage(map[uint16]string{1: "WIN-TEST", 2: "CORP", 3: "win-test.corp.local"}, 10, 0, 20348)
+	port := serveRDP(t, challenge)
+
+	n := New(&Options{VersionIntensity: 9, Timeout: 1})
+	options := n.DefaultScanOptions()
+	options.Probes = []string{"RDPNegotiation"}
+	options.Timeouts.Read = time.Second
+	response := n.ScanWithOptions(context.Background(), TCP, "127.0.0.1", port, options)
+	if assert.Equal(t, StatusMatched, response.Status) {
+		assert.Equal(t, "ms-wbt-server", response.Service.Service)
+		assert.Equal(t, "Microsoft Terminal Services", response.Service.Product)
+		assert.Equal(t, "10.0.20348", response.Service.Version)
+		assert.Equal(t, "WIN-TEST", response.Service.Hostname)
+		assert.Equal(t, "security: TLS, CredSSP", response.Service.Info)
+		if assert.NotNil(t, response.RDP) {
+			assert.Equal(t, []string{"TLS", "CredSSP"}, response.RDP.SecurityLayers)
+			if assert.NotNil(t, response.RDP.NTLM) {
+				assert.Equal(t, "CORP", response.RDP.NTLM.NetBIOSDomainName)
+				assert.Equal(t, "win-test.corp.local", response.RDP.NTLM.DNSComputerName)
+			}
+		}
+	}
+}
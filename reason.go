@@ -0,0 +1,95 @@
+package gonmap
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// Response.Reason 的取值 与 nmap 的 reason 属性对应
+const (
+	// ReasonSynAck 连接建立成功
+	ReasonSynAck = "syn-ack"
+	// ReasonConnRefused 对端返回 RST
+	ReasonConnRefused = "conn-refused"
+	// ReasonNoResponse 连接超时 没有任何响应
+	ReasonNoResponse  = "no-response"
+	ReasonHostUnreach = "host-unreach"
+	ReasonNetUnreach  = "net-unreach"
+	// ReasonPortUnreach UDP 端口返回 ICMP 端口不可达
+	ReasonPortUnreach = "port-unreach"
+	// ReasonUDPResponse UDP 端口返回了数据
+	ReasonUDPResponse = "udp-response"
+	// ReasonProxyError 代理连接失败或代理返回错误 无法判断目标状态
+	ReasonProxyError = "proxy-error"
+	// ReasonResourceError 本地资源不足 例如文件描述符或临时端口耗尽 结果不可信 需要重试
+	ReasonResourceError = "resource-error"
+	// ReasonError 无法归类的连接错误
+	ReasonError = "error"
+)
+
+// classifyDialError 根据连接错误判断端口状态
+// 经过代理时 除代理返回的结果外 连接错误都属于代理本身 不代表目标的状态
+func classifyDialError(err error, viaProxy bool) (Status, string) {
+	switch {
+	case isResourceError(err):
+		return StatusResourceError, ReasonResourceError
+	case isTimeout(err):
+		return StatusFiltered, ReasonNoResponse
+	case viaProxy:
+		return classifyProxyError(err)
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return StatusClose, ReasonConnRefused
+	case errors.Is(err, syscall.EHOSTUNREACH):
+		return StatusFiltered, ReasonHostUnreach
+	case errors.Is(err, syscall.ENETUNREACH):
+		return StatusFiltered, ReasonNetUnreach
+	}
+	return StatusFiltered, ReasonError
+}
+
+// classifyProxyError 解析 SOCKS5 代理返回的连接结果
+func classifyProxyError(err error) (Status, string) {
+	msg := err.Error()
+	switch {
+	case strings.HasSuffix(msg, "unknown error connection refused"):
+		return StatusClose, ReasonConnRefused
+	case strings.HasSuffix(msg, "unknown error host unreachable"):
+		return StatusFiltered, ReasonHostUnreach
+	case strings.HasSuffix(msg, "unknown error network unreachable"):
+		return StatusFiltered, ReasonNetUnreach
+	case strings.HasSuffix(msg, "unknown error TTL expired"):
+		return StatusFiltered, ReasonNoResponse
+	}
+	return StatusProxyError, ReasonProxyError
+}
+
+// dialError 连接失败时需要返回给调用方的错误 端口关闭和过滤属于正常的扫描结果
+func dialError(status Status, err error) error {
+	switch status {
+	case StatusProxyError:
+		return wrapError(ErrProxy, err)
+	case StatusResourceError:
+		return err
+	}
+	return nil
+}
+
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func isResourceError(err error) bool {
+	for _, errno := range []syscall.Errno{syscall.EMFILE, syscall.ENFILE, syscall.ENOBUFS, syscall.ENOMEM, syscall.EADDRNOTAVAIL} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package gonmap
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// redisInfoFields INFO server 中记录到 Details 的字段
+var redisInfoFields = []string{"redis_version", "redis_mode", "os", "arch_bits", "process_id", "run_id", "tcp_port", "uptime_in_seconds", "executable", "config_file"}
+
+// redisDetector 发送 INFO server 不需要认证时解析版本和运行信息
+type redisDetector struct{}
+
+func (redisDetector) Name() string       { return "RedisInfo" }
+func (redisDetector) Protocol() Protocol { return TCP }
+func (redisDetector) Ports() []int       { return []int{6379} }
+func (redisDetector) SSLPorts() []int    { return []int{6380} }
+func (redisDetector) Services() []string { return []string{"redis"} }
+func (redisDetector) Rarity() int        { return 8 }
+
+func (redisDetector) Detect(ctx context.Context, conn net.Conn, target Target) (*MatchResult, error) {
+	if _, err := conn.Write([]byte("INFO server\r\n")); err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	result := &MatchResult{Service: "redis", Product: "Redis key-value store", Response: []byte(line)}
+	switch {
+	case strings.HasPrefix(line, "-NOAUTH"), strings.HasPrefix(line, "-WRONGPASS"):
+		result.Info = "auth required"
+		result.Details = map[string]any{"auth_required": true}
+		return result, nil
+	case strings.HasPrefix(line, "-DENIED"):
+		result.Info = "protected mode"
+		result.Details = map[string]any{"protected_mode": true}
+		return result, nil
+	case strings.HasPrefix(line, "-ERR"):
+		// 旧版本不支持 INFO 的参数
+		result.Details = map[string]any{"error": strings.TrimPrefix(line, "-")}
+		return result, nil
+	case !strings.HasPrefix(line, "$"):
+		return nil, nil
+	}
+	size, err := strconv.Atoi(line[1:])
+	if err != nil || size < 0 || size > 64*1024 {
+		return nil, nil
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return nil, err
+	}
+	result.Response = append(append(result.Response, "\r\n"...), body...)
+	info := make(map[string]string)
+	for _, field := range strings.Split(string(body), "\n") {
+		key, value, ok := strings.Cut(strings.TrimRight(field, "\r"), ":")
+		if ok {
+			info[key] = value
+		}
+	}
+	if info["redis_version"] == "" {
+		return nil, nil
+	}
+	details := map[string]any{"auth_required": false}
+	for _, key := range redisInfoFields {
+		if value, ok := info[key]; ok {
+			details[key] = value
+		}
+	}
+	result.Version = info["redis_version"]
+	result.CPE = []string{fmt.Sprintf("cpe:/a:redislabs:redis:%s", result.Version)}
+	result.Info = "anonymous access"
+	result.OS = info["os"]
+	result.Details = details
+	return result, nil
+}
+
+func init() {
+	mustRegisterDetector(redisDetector{})
+}
@@ -0,0 +1,238 @@
+package gonmap
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ProbeSpec 通过接口注册的探针 字段与探针文件中的指令对应
+type ProbeSpec struct {
+	Name     string
+	Protocol Protocol
+	// 发送的数据 其中的 {Host} 在发送时替换为目标地址
+	Payload  []byte
+	Ports    []int
+	SSLPorts []int
+	// 1-9 为 0 时按默认值 5 处理
+	Rarity     int
+	TotalWait  time.Duration
+	TCPWrapped time.Duration
+	Fallback   []string
+	NoPayload  bool
+	SourcePort int
+	// 至少包含一条规则 与探针文件一致 没有规则的探针不会加载
+	Rules []MatchSpec
+}
+
+// MatchSpec 通过接口注册的 match/softmatch 规则 版本字段支持 $1 $P() $SUBST() $I() 模板
+type MatchSpec struct {
+	Service    string
+	Pattern    string
+	IgnoreCase bool // 正则选项 i
+	DotAll     bool // 正则选项 s
+	Soft       bool
+	Product    string
+	Version    string
+	Info       string
+	Hostname   string
+	OS         string
+	DeviceType string
+	// 例如 cpe:/a:openbsd:openssh:$1 省略 cpe:/ 前缀时自动补全
+	CPE []string
+}
+
+// RegisterProbe 注册新的探针 同协议下已有同名探针时返回错误
+// 注册后新的扫描立即生效 Reload 之后依然保留
+func (n *Nmap) RegisterProbe(spec ProbeSpec) error {
+	if _, err := spec.build(); err != nil {
+		return err
+	}
+	return n.register(func(db *probeDB) error {
+		p, err := spec.build()
+		if err != nil {
+			return err
+		}
+		for _, exist := range db.probes(p.protocol) {
+			if exist.Name == p.Name {
+				return fmt.Errorf("probe %s %s already exists", p.protocol, p.Name)
+			}
+		}
+		if p.protocol == TCP {
+			db.tcpProbes = append(db.tcpProbes, p)
+		} else {
+			db.udpProbes = append(db.udpProbes, p)
+		}
+		return nil
+	})
+}
+
+// AddMatch 为已有的探针追加 match/softmatch 规则 规则排在探针原有规则之后
+// 注册后新的扫描立即生效 Reload 之后依然保留
+func (n *Nmap) AddMatch(protocol Protocol, probeName string, spec MatchSpec) error {
+	if _, err := spec.build(); err != nil {
+		return err
+	}
+	return n.register(func(db *probeDB) error {
+		m, err := spec.build()
+		if err != nil {
+			return err
+		}
+		for _, p := range db.probes(protocol) {
+			if p.Name == probeName {
+				p.matchGroup = append(p.matchGroup[:len(p.matchGroup):len(p.matchGroup)], m)
+				services := make(map[string]struct{}, len(p.services)+1)
+				for service := range p.services {
+					services[service] = struct{}{}
+				}
+				services[m.service] = struct{}{}
+				p.services = services
+				return nil
+			}
+		}
+		return fmt.Errorf("probe %s %s not found", protocol, probeName)
+	})
+}
+
+// register 在探针库的副本上执行修改并原子替换 正在进行的扫描不受影响
+// 修改会被记录 Reload 之后重新执行
+func (n *Nmap) register(apply func(db *probeDB) error) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	db := n.probeDB().clone()
+	if err := apply(db); err != nil {
+		return err
+	}
+	setFallback(db.tcpProbes)
+	setFallback(db.udpProbes)
+	n.db.Store(db)
+	n.registrations = append(n.registrations, apply)
+	return nil
+}
+
+// clone 复制探针库 探针结构体逐个复制 规则和端口列表在修改时再复制
+func (db *probeDB) clone() *probeDB {
+	copyProbes := func(probes []*probe) []*probe {
+		result := make([]*probe, len(probes))
+		for i, p := range probes {
+			cp := *p
+			result[i] = &cp
+		}
+		return result
+	}
+	exclude := make(map[Protocol]PortList, len(db.exclude))
+	for protocol, ports := range db.exclude {
+		exclude[protocol] = ports
+	}
+	return &probeDB{
+		tcpProbes: copyProbes(db.tcpProbes),
+		udpProbes: copyProbes(db.udpProbes),
+		exclude:   exclude,
+		warnings:  append([]string(nil), db.warnings...),
+	}
+}
+
+// build 按照探针文件的规则校验并生成探针
+func (spec ProbeSpec) build() (*probe, error) {
+	p := newProbe()
+	if spec.Protocol != TCP && spec.Protocol != UDP {
+		return nil, fmt.Errorf("probe 协议不正确: %s", spec.Protocol)
+	}
+	p.protocol = spec.Protocol
+	if !probeNameRegx.MatchString(spec.Name) {
+		return nil, errors.New("probe 名称不正确:" + spec.Name)
+	}
+	p.Name = spec.Name
+	p.sendRaw = string(spec.Payload)
+	var err error
+	if p.ports, err = checkPorts(spec.Ports); err != nil {
+		return nil, err
+	}
+	if p.sslports, err = checkPorts(spec.SSLPorts); err != nil {
+		return nil, err
+	}
+	if spec.Rarity != 0 {
+		if spec.Rarity < 1 || spec.Rarity > 9 {
+			return nil, fmt.Errorf("rarity 必须在 1-9 之间: %d", spec.Rarity)
+		}
+		p.rarity, p.hasRarity = spec.Rarity, true
+	}
+	if spec.TotalWait < 0 || spec.TCPWrapped < 0 {
+		return nil, errors.New("等待时间不能为负数")
+	}
+	p.totalWaiTms = spec.TotalWait
+	p.tcpwrappedms = spec.TCPWrapped
+	if spec.SourcePort < 0 || spec.SourcePort > 65535 {
+		return nil, fmt.Errorf("probe source 端口不正确: %d", spec.SourcePort)
+	}
+	p.sourcePort = spec.SourcePort
+	p.noPayload = spec.NoPayload
+	for _, fb := range spec.Fallback {
+		if !probeNameRegx.MatchString(fb) {
+			return nil, errors.New("fallback 探针名称不正确:" + fb)
+		}
+		p.fallback = append(p.fallback, fb)
+	}
+	if len(spec.Rules) == 0 {
+		return nil, fmt.Errorf("probe %s 没有 match 规则", spec.Name)
+	}
+	for _, rule := range spec.Rules {
+		m, err := rule.build()
+		if err != nil {
+			return nil, err
+		}
+		p.matchGroup = append(p.matchGroup, m)
+		p.services[m.service] = struct{}{}
+	}
+	return p, nil
+}
+
+func checkPorts(ports []int) (PortList, error) {
+	for _, port := range ports {
+		if port < 1 || port > 65535 {
+			return nil, fmt.Errorf("端口不正确: %d", port)
+		}
+	}
+	return append(PortList(nil), ports...).removeDuplicate(), nil
+}
+
+// build 按照探针文件的规则校验并生成 match 规则
+func (spec MatchSpec) build() (*match, error) {
+	service := strings.TrimSpace(spec.Service)
+	if service == "" || strings.ContainsAny(service, " \t") {
+		return nil, errors.New("match 服务名称不正确: " + spec.Service)
+	}
+	var opt string
+	if spec.IgnoreCase {
+		opt += "i"
+	}
+	if spec.DotAll {
+		opt += "s"
+	}
+	regex, err := getPatternRegexp(spec.Pattern, opt)
+	if err != nil {
+		return nil, err
+	}
+	vm := &versionMate{
+		ProductName:     spec.Product,
+		Version:         spec.Version,
+		Info:            spec.Info,
+		Hostname:        spec.Hostname,
+		OperatingSystem: spec.OS,
+		DeviceType:      spec.DeviceType,
+	}
+	for _, cpe := range spec.CPE {
+		if !strings.HasPrefix(cpe, "cpe:/") {
+			cpe = "cpe:/" + cpe
+		}
+		vm.CPE = append(vm.CPE, cpe)
+	}
+	return &match{
+		soft:        spec.Soft,
+		service:     FixProtocol(service),
+		pattern:     spec.Pattern,
+		regex:       regex,
+		versionMate: vm,
+	}, nil
+}
@@ -5,13 +5,12 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
-	"github.com/logrusorgru/aurora"
 	"io"
 	"net"
 	"strings"
+	"syscall"
 	"time"
 
-	"github.com/projectdiscovery/gologger"
 	"golang.org/x/net/proxy"
 )
 
@@ -65,47 +64,128 @@ type SocketStatus struct {
 	err    error
 }
 
+// scanConfig 单次扫描生效的配置
+type scanConfig struct {
+	timeouts Timeouts
+	// 版本探测强度 0-9 决定发送哪些探针
+	intensity int
+	// 探针白名单和黑名单 为空时不限制
+	allowProbes map[string]struct{}
+	denyProbes  map[string]struct{}
+	tls         TLSMode
+	serverName  string
+	// 单个探针读取响应的最大字节数
+	maxBannerSize int
+	// 验证模式下期望的服务 只发送可能识别这些服务的探针
+	expect map[string]struct{}
+	// 大于 0 时每个端口最多发送的探针数
+	maxProbes int
+	logger    Logger
+	// 不为空时获取 http/https 服务的页面信息
+	http *HTTPOptions
+	// 为 true 时计算 TLS 端口的 JARM 和 JA3S
+	tlsFingerprint bool
+	// 不为空时获取 ssh 服务的算法和主机密钥
+	ssh *SSHOptions
+}
+
+func (n *Nmap) defaultScanConfig() *scanConfig {
+	return &scanConfig{
+		timeouts:      n.option.timeouts(),
+		intensity:     clampIntensity(n.option.VersionIntensity),
+		maxBannerSize: defaultMaxBannerSize,
+		logger:        n.option.logger(),
+	}
+}
+
+func clampIntensity(intensity int) int {
+	if intensity < 0 {
+		return 0
+	}
+	if intensity > 9 {
+		return 9
+	}
+	return intensity
+}
+
 func (n *Nmap) ScanAddress(protocol Protocol, address string) (response *Response, err error) {
 	ip, port, err := ParseAddress(address)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(ErrInvalidTarget, err)
 	}
 	ctx := context.Background()
-	response = n.ScanTimeout(ctx, protocol, ip, port,
-		time.Duration(n.option.Timeout)*time.Second,
-		time.Duration(n.option.Timeout)*time.Second*10)
+	response = n.scan(ctx, protocol, ip, port, n.defaultScanConfig())
 	return response, nil
 }
 
+// ScanTimeout timeout 为连接和读取超时 maxTimeout 为单个端口的总预算
 func (n *Nmap) ScanTimeout(ctx context.Context, protocol Protocol, ip string, port int, timeout, maxTimeout time.Duration) (response *Response) {
-	// 两种取消方式一种 ctx 取消 一种是 最大超时时间取消 这里只限制最大超时时间 即一个目标检测的最大超时时间
-	ctx, cancel := context.WithTimeout(ctx, maxTimeout)
+	cfg := n.defaultScanConfig()
+	cfg.timeouts.Connect = timeout
+	cfg.timeouts.Read = timeout
+	cfg.timeouts.Port = maxTimeout
+	return n.scan(ctx, protocol, ip, port, cfg)
+}
+
+// ScanWithIntensity 使用指定的版本探测强度扫描 探针库只加载一次 不同强度的扫描可以共用同一个 Nmap
+func (n *Nmap) ScanWithIntensity(ctx context.Context, protocol Protocol, ip string, port int, intensity int) (response *Response) {
+	options := n.DefaultScanOptions()
+	options.VersionIntensity = &intensity
+	return n.ScanWithOptions(ctx, protocol, ip, port, options)
+}
+
+// scan 在端口预算和主机预算内完成识别 预算耗尽时在 Response 中标明
+func (n *Nmap) scan(ctx context.Context, protocol Protocol, ip string, port int, cfg *scanConfig) (response *Response) {
+	if ip == "" || port < 1 || port > 65535 {
+		response = &Response{Status: StatusUnknown, Address: net.JoinHostPort(ip, fmt.Sprint(port)), Protocol: protocol}
+		response.setError(fmt.Errorf("%w: %s", ErrInvalidTarget, response.Address))
+		return response
+	}
+	parent := ctx
+	ctx, cancel, budget := n.scanDeadline(ctx, ip, cfg.timeouts)
 	defer cancel()
 	if port == 53 {
 		protocol = UDP
 	}
-	response = &Response{Status: StatusUnknown, Address: fmt.Sprintf("%s:%d", ip, port), Protocol: protocol}
-	go func() {
-		defer cancel()
-		switch protocol {
-		case TCP:
-			response = n.ScanTCP(ctx, ip, port, timeout)
-		case UDP:
-			response = n.ScanUdp(ctx, ip, port, timeout)
-		default:
-			panic(protocol)
-		}
-	}()
-	select {
-	case <-ctx.Done():
+	if !n.option.AllPorts && n.probeDB().isExcluded(protocol, port) {
+		response = &Response{Status: StatusExcluded, Address: net.JoinHostPort(ip, fmt.Sprint(port)), Protocol: protocol}
+		response.setError(ErrExcludedPort)
 		return response
 	}
+	switch protocol {
+	case TCP:
+		response = n.scanTCP(ctx, ip, port, cfg)
+	case UDP:
+		response = n.scanUdp(ctx, ip, port, cfg)
+	default:
+		panic(protocol)
+	}
+	if response.Status == StatusUnknown {
+		response.Budget = budget()
+		switch response.Budget {
+		case BudgetPort, BudgetHost:
+			response.setError(fmt.Errorf("%w: %s", ErrTimeoutBudget, response.Budget))
+		case BudgetContext:
+			response.setError(parent.Err())
+		}
+	}
+	guessService(response, port)
+	if cfg.http != nil && response.Status == StatusMatched && isHTTPService(response.Service.Service) {
+		n.enrichHTTP(ctx, response, ip, port, cfg)
+	}
+	if cfg.ssh != nil && response.Status == StatusMatched && plainService(response.Service.Service) == "ssh" {
+		n.enrichSSH(ctx, response, ip, port, cfg)
+	}
+	if cfg.tlsFingerprint && response.Tls {
+		n.fingerprintTLS(ctx, response, ip, port, cfg)
+	}
+	return response
 }
 
 func (n *Nmap) ScanProbes(protocol Protocol, address string, timeout time.Duration) (response *Response, err error) {
 	ip, port, err := ParseAddress(address)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(ErrInvalidTarget, err)
 	}
 	ctx := context.Background()
 	response = n.ScanTimeout(ctx, protocol, ip, port, timeout, timeout)
@@ -113,240 +193,351 @@ func (n *Nmap) ScanProbes(protocol Protocol, address string, timeout time.Durati
 }
 
 func (n *Nmap) ScanTCP(ctx context.Context, ip string, port int, timeout time.Duration) (response *Response) {
-	if timeout < time.Duration(1)*time.Second {
-		gologger.Warning().Msgf("timeout too small: %vs", timeout.Seconds())
-		timeout = time.Duration(10) * time.Second
+	cfg := n.defaultScanConfig()
+	cfg.timeouts.Connect = timeout
+	cfg.timeouts.Read = timeout
+	return n.scanTCP(ctx, ip, port, cfg)
+}
+
+func (n *Nmap) scanTCP(ctx context.Context, ip string, port int, cfg *scanConfig) (response *Response) {
+	timeouts := cfg.timeouts
+	if timeouts.Connect < time.Duration(1)*time.Second {
+		cfg.logger.Warnf("timeout too small: %vs", timeouts.Connect.Seconds())
+		timeouts.Connect = defaultConnectTimeout
 	}
-	response = &Response{Status: StatusUnknown, Address: fmt.Sprintf("%s:%d", ip, port), Protocol: TCP}
+	response = &Response{Status: StatusUnknown, Address: net.JoinHostPort(ip, fmt.Sprint(port)), Protocol: TCP}
 	// create dialer
-	dialer, err := NewDialer(n.option.Proxy, timeout)
+	dialer, err := NewDialer(n.option.Proxy, timeouts.Connect)
 	if err != nil {
-		gologger.Error().Msgf("Failed to create dialer: %s", err)
+		cfg.logger.Errorf("Failed to create dialer: %s", err)
+		response.setError(wrapError(ErrDialer, err))
 		return response
 	}
-	address := fmt.Sprintf("%s:%d", ip, port)
-	isTls := false
-	probesSorts := sortProbes(n.tcpProbes, port, false)
+	address := net.JoinHostPort(ip, fmt.Sprint(port))
+	isTls := cfg.tls == TLSOn
+	// 扫描过程中使用同一份探针库 不受 Reload 影响
+	db := n.probeDB()
+	probesSorts := cfg.tcpProbes(db, port, isTls)
 	if 0 == len(probesSorts) {
 		return response
 	}
 	i := 0
+	sent := 0
 	statusCheck := PortStatusCheck{}
+	connected := false
+	// 最后一次 TLS 握手或写入错误 没有识别结果时返回给调用方
+	var lastErr error
+	// softmatch 之后只发送能识别同一服务的探针 寻找更具体的匹配
+	var soft *softMatch
 
-	for {
-		select {
-		case <-ctx.Done():
-			return response
-		default:
-		}
-		if i >= len(probesSorts) {
+	for ctx.Err() == nil && i < len(probesSorts) {
+		if cfg.maxProbes > 0 && sent >= cfg.maxProbes {
+			response.Truncated = true
 			break
 		}
 		pb := probesSorts[i]
 		// 放在这里++ 是避免后面continue 忘记++
 		i++
+		sent++
 		if n.option.VersionTrace {
 			if isTls {
-				gologger.Print().Msgf("Service scan sending probe %s to tls:%s (tcp)", pb.Name, address)
+				cfg.logger.Infof("Service scan sending probe %s to tls:%s (tcp)", pb.Name, address)
 			} else {
-				gologger.Print().Msgf("Service scan sending probe %s to %s (tcp) ", pb.Name, address)
+				cfg.logger.Infof("Service scan sending probe %s to %s (tcp)", pb.Name, address)
 			}
 		}
 		t1 := time.Now()
-		banner, code := n.tcpSend(dialer, address, isTls, pb, timeout)
+		var banner []byte
+		var code PortStatus
+		var detected *MatchResult
+		if pb.detector != nil {
+			target := Target{Host: ip, Port: port, Protocol: TCP, TLS: isTls, ServerName: cfg.serverName}
+			detected, code, err = runDetector(ctx, dialer, pb, target, timeouts.Connect, probeWait(pb, timeouts))
+			if detected != nil {
+				banner = detected.Response
+			} else if err != nil && code == StatusPortOpen {
+				cfg.logger.Debugf("Detector %s error: %v", pb.Name, err)
+			}
+		} else {
+			banner, code, err = n.tcpSend(ctx, dialer, address, isTls, pb, timeouts, cfg)
+		}
 		if n.option.DebugResponse {
-			gologger.Print().Msgf("Read request from [%s] [%s] (timeout: %s)\n%s", address, aurora.Cyan(code.String()), time.Now().Sub(t1).String(), FormatBytesToHex(banner))
+			cfg.logger.Infof("Read request from [%s] [%s] (timeout: %s)\n%s", address, code.String(), time.Now().Sub(t1).String(), FormatBytesToHex(banner))
 		}
 		costTime := time.Now().Sub(t1)
-		// check
-		if len(banner) == 0 && pb.isTcpWrapPossible() && costTime < pb.tcpwrappedms && statusCheck.Open == 0 {
-			response.Status = StatusTcpWrapped
-			return response
-		}
 		if code == StatusPortClose {
-			statusCheck.SetClose()
-			if statusCheck.IsClose() {
-				response.Status = StatusClose
+			// 端口还没有成功连接过时 第一次连接失败即可判断端口状态 中断时不判断
+			if !connected && ctx.Err() == nil {
+				response.Status, response.Reason = classifyDialError(err, n.option.Proxy != "")
+				response.setError(dialError(response.Status, err))
 				return response
 			}
 			continue
-		} else if code == StatusTlsError {
-			continue
-		} else if code == StatusWriteTimeout {
+		}
+		connected = true
+		response.Reason = ReasonSynAck
+		// check 对端在 tcpwrappedms 内主动断开且没有任何数据 读取超时不算
+		if len(banner) == 0 && code == StatusPortOpen && pb.isTcpWrapPossible() && costTime < pb.tcpwrappedms && statusCheck.Open == 0 {
+			response.Status = StatusTcpWrapped
+			return response
+		}
+		if code == StatusTlsError || code == StatusWriteTimeout {
+			lastErr = err
 			continue
 		} else if code == StatusReadTimeout && len(banner) == 0 {
 			statusCheck.SetOpen()
 			continue
 		}
 		statusCheck.SetOpen()
-		finger := pb.match(banner)
+		if len(response.Banner) == 0 {
+			response.Banner = banner
+		}
+		finger := detected
+		if pb.detector == nil {
+			finger = pb.match(banner)
+		}
 		if finger != nil {
-			gologger.Debug().Msgf("Matched :%v with %s:%d %v", finger.Service, pb.Name, finger.match.line, finger.Version)
-			if pb.Name == "TLSSessionReq" || pb.Name == "SSLSessionReq" {
+			cfg.logger.Debugf("Matched :%v with %s:%d %v", finger.Service, pb.Name, finger.line(), finger.Version)
+			if isTlsProbe(pb) && cfg.tls == TLSAuto {
 				isTls = true
-				probesSorts = sortProbes(n.tcpProbes, port, true)
+				probesSorts = cfg.tcpProbes(db, port, true)
 				i = 0
+				soft = nil
 				continue
 			}
 			finger.Response = banner
 			finger.Service = fixServiceName(finger.Service, isTls)
-			response.Status = StatusMatched
-			response.Tls = isTls
-			response.Service = finger
+			expected := portHinted(pb, port, isTls)
+			if finger.Soft {
+				if soft == nil {
+					soft = &softMatch{result: finger, expected: expected}
+					probesSorts = append(probesSorts[:i:i], sameService(probesSorts[i:], finger.ruleService())...)
+				} else if soft.result.Service == finger.Service {
+					soft.agree++
+				}
+				continue
+			}
+			agree := 0
+			if soft != nil && soft.result.Service == finger.Service {
+				agree = soft.agree + 1
+			}
+			setMatched(response, finger, expected, isTls, agree)
 			return response
 		}
 	}
+	if soft != nil {
+		setMatched(response, soft.result, soft.expected, isTls, soft.agree)
+		return response
+	}
 	response.Status = StatusUnknown
+	response.setError(lastErr)
 	return response
 }
 
 func (n *Nmap) ScanUdp(ctx context.Context, ip string, port int, timeout time.Duration) (response *Response) {
+	cfg := n.defaultScanConfig()
+	cfg.timeouts.Read = timeout
+	return n.scanUdp(ctx, ip, port, cfg)
+}
+
+func (n *Nmap) scanUdp(ctx context.Context, ip string, port int, cfg *scanConfig) (response *Response) {
 	// 根据端口获取默认协议
-	address := fmt.Sprintf("%s:%d", ip, port)
+	address := net.JoinHostPort(ip, fmt.Sprint(port))
 	remoteAddr, _ := net.ResolveUDPAddr("udp", address)
-	response = &Response{Status: StatusUnknown, Address: fmt.Sprintf("%s:%d", ip, port), Protocol: UDP}
-	for _, pb := range n.udpProbes {
-		select {
-		case <-ctx.Done():
-			return response
-		default:
+	response = &Response{Status: StatusUnknown, Address: net.JoinHostPort(ip, fmt.Sprint(port)), Protocol: UDP}
+	sent := 0
+	var soft *softMatch
+	for _, pb := range cfg.sortProbes(cfg.selectProbes(n.probeDB().udpProbes, port, false), port, false) {
+		if ctx.Err() != nil {
+			break
 		}
-		sendRaw := strings.Replace(pb.sendRaw, "{Host}", fmt.Sprintf("%s:%d", ip, port), -1)
-		banner, err := udpSend(remoteAddr, []byte(sendRaw), timeout)
-		if err != nil && strings.Contains(err.Error(), "STEP1:CONNECT") {
-			response.Status = StatusClose
-			return response
+		if cfg.maxProbes > 0 && sent >= cfg.maxProbes {
+			response.Truncated = true
+			break
+		}
+		sent++
+		var banner []byte
+		var err error
+		var finger *MatchResult
+		if pb.detector != nil {
+			target := Target{Host: ip, Port: port, Protocol: UDP, ServerName: cfg.serverName}
+			finger, _, err = runDetector(ctx, nil, pb, target, cfg.timeouts.Connect, probeWait(pb, cfg.timeouts))
+			if finger != nil {
+				banner = finger.Response
+			}
+		} else {
+			sendRaw := strings.Replace(pb.sendRaw, "{Host}", cfg.hostValue(ip, port), -1)
+			banner, err = udpSend(ctx, remoteAddr, pb.sourcePort, []byte(sendRaw), probeWait(pb, cfg.timeouts))
+			finger = n.Match(UDP, banner, pb.Name)
+		}
+		if err != nil && !isTimeout(err) && ctx.Err() == nil {
+			// ICMP 端口不可达表现为 ECONNREFUSED
+			if errors.Is(err, syscall.ECONNREFUSED) {
+				response.Status, response.Reason = StatusClose, ReasonPortUnreach
+				return response
+			}
+			if status, reason := classifyDialError(err, false); status == StatusResourceError {
+				response.Status, response.Reason = status, reason
+				response.setError(err)
+				return response
+			}
+		}
+		if len(banner) > 0 {
+			response.Reason = ReasonUDPResponse
 		}
 		if n.option.DebugResponse {
-			gologger.Info().Msgf("banner:%v", string(banner))
+			cfg.logger.Infof("banner:%v", string(banner))
 		}
-		if finger := n.Match(UDP, banner, pb.Name); finger != nil {
-			response.Status = StatusMatched
-			response.Service = finger
+		if len(response.Banner) == 0 {
+			response.Banner = banner
+		}
+		if finger != nil {
+			expected := portHinted(pb, port, false)
+			if finger.Soft {
+				if soft == nil {
+					soft = &softMatch{result: finger, expected: expected}
+				} else if soft.result.Service == finger.Service {
+					soft.agree++
+				}
+				continue
+			}
+			agree := 0
+			if soft != nil && soft.result.Service == finger.Service {
+				agree = soft.agree + 1
+			}
+			setMatched(response, finger, expected, false, agree)
 			return response
 		}
 	}
+	if soft != nil {
+		setMatched(response, soft.result, soft.expected, false, soft.agree)
+		return response
+	}
 	response.Status = StatusUnknown
 	return response
 }
 
-func (n *Nmap) tcpSend(dialer proxy.Dialer, address string, ssl bool, pb *probe, duration time.Duration) ([]byte, PortStatus) {
-	var maxWait time.Duration
-	if pb.totalWaiTms > 0 {
-		maxWait = pb.totalWaiTms
-	} else {
-		maxWait = time.Second * 30
+// probeWait 单个探针等待响应的时间 取读取超时和探针 totalwaitms 中的较小者
+func probeWait(pb *probe, timeouts Timeouts) time.Duration {
+	wait := timeouts.Read
+	if pb.totalWaiTms > 0 && pb.totalWaiTms < wait {
+		wait = pb.totalWaiTms
 	}
-	if duration > maxWait {
-		duration = maxWait
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), maxWait)
-	defer cancel()
+	return wait
+}
+
+func (n *Nmap) tcpSend(ctx context.Context, dialer proxy.Dialer, address string, ssl bool, pb *probe, timeouts Timeouts, cfg *scanConfig) ([]byte, PortStatus, error) {
 	if n.option.VersionTrace {
-		gologger.Debug().Msgf("Service scan sending probe %s to %s (tcp)", pb.Name, address)
+		cfg.logger.Debugf("Service scan sending probe %s to %s (tcp)", pb.Name, address)
 	}
-	data := strings.Replace(pb.sendRaw, "{Host}", address, -1)
+	host, port, _ := ParseAddress(address)
+	data := strings.Replace(pb.sendRaw, "{Host}", cfg.hostValue(host, port), -1)
 	if n.option.DebugRequest {
-		gologger.Print().Msgf("Send Prob:%s raw\n%s", pb.Name, FormatBytesToHex([]byte(data)))
+		cfg.logger.Infof("Send Prob:%s raw\n%s", pb.Name, FormatBytesToHex([]byte(data)))
 	}
 	//读取数据
 	socketStatus := &SocketStatus{}
-	done := make(chan bool)
-	// 这里主要控制指纹中的WaitMS
-	go func() {
-		sendProbe(ctx, dialer, address, ssl, []byte(data), duration, socketStatus)
-		done <- true
-		close(done)
-	}()
-
-	select {
-	case <-done:
-		return socketStatus.data, socketStatus.status
-	case <-ctx.Done():
-		return socketStatus.data, socketStatus.status
+	var tlsConfig *tls.Config
+	if ssl {
+		tlsConfig = &tls.Config{InsecureSkipVerify: true, ServerName: cfg.serverName}
 	}
+	sendProbe(ctx, dialer, address, tlsConfig, []byte(data), timeouts.Connect, probeWait(pb, timeouts), cfg.maxBannerSize, socketStatus, cfg.logger)
+	return socketStatus.data, socketStatus.status, socketStatus.err
 }
 
-func sendProbe(ctx context.Context, dialer proxy.Dialer, address string, ssl bool, data []byte, timeout time.Duration, conStatus *SocketStatus) {
-	conn, err := dialer.Dial("tcp", address)
+// sendProbe 发送探针并读取响应 connectTimeout 限制连接和 TLS 握手 wait 限制读取响应的总时间
+// tlsConfig 不为空时通过 TLS 发送 响应最多读取 size 字节 所有阻塞操作同时受 ctx 限制
+func sendProbe(ctx context.Context, dialer proxy.Dialer, address string, tlsConfig *tls.Config, data []byte, connectTimeout, wait time.Duration, size int, conStatus *SocketStatus, logger Logger) {
+	dialCtx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+	conn, err := dialContext(dialCtx, dialer, "tcp", address)
 	if err != nil {
-		gologger.Debug().Msgf("CreteCon Error:%v", err)
+		logger.Debugf("CreteCon Error:%v", err)
 		conStatus.status = StatusPortClose
+		conStatus.err = err
 		return
 	}
 	defer conn.Close()
-	if ssl {
-		tlsConn := tls.Client(conn, &tls.Config{
-			InsecureSkipVerify: true,
-		})
-		if err := tlsConn.HandshakeContext(ctx); err != nil {
-			gologger.Debug().Msgf("TLS Error:%v", err)
+	// ctx 结束时立即中断阻塞的读写
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Now())
+	})
+	defer stop()
+	if tlsConfig != nil {
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(dialCtx); err != nil {
+			logger.Debugf("TLS Error:%v", err)
 			conStatus.status = StatusTlsError
+			conStatus.err = err
 			return
 		}
 		conn = tlsConn
 	}
 	if len(data) > 0 {
+		_ = conn.SetWriteDeadline(time.Now().Add(connectTimeout))
 		_, err = conn.Write(data)
 		if err != nil {
-			gologger.Debug().Msgf("Write Error:%v", err)
+			logger.Debugf("Write Error:%v", err)
 			conStatus.status = StatusWriteTimeout
+			conStatus.err = err
 			return
 		}
 	}
-	size := 4096
 	var tmp = make([]byte, 1024)
 	var length int
+	deadline := time.Now().Add(wait)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
 	for {
-		select {
-		case <-ctx.Done():
-			// 如果已经读取到数据，保持 StatusPortOpen
-			if len(conStatus.data) > 0 {
-				conStatus.status = StatusPortOpen
-			} else {
-				conStatus.status = StatusReadTimeout
-			}
-			return
-		default:
-			if len(conStatus.data) > size {
+		_ = conn.SetReadDeadline(deadline)
+		length, err = conn.Read(tmp)
+		if length > 0 {
+			conStatus.status = StatusPortOpen
+			// 填充数据 超过上限的部分丢弃
+			conStatus.data = append(conStatus.data, tmp[:length]...)
+			if len(conStatus.data) >= size {
+				conStatus.data = conStatus.data[:size]
 				return
 			}
-			err = conn.SetReadDeadline(time.Now().Add(timeout))
-			length, err = conn.Read(tmp)
-			if err != nil {
-				gologger.Debug().Msgf("Read Error:%v", err)
-			}
-			if length > 0 {
-				conStatus.status = StatusPortOpen
-				// 填充数据
-				conStatus.data = append(conStatus.data, tmp[:length]...)
-				if length < len(tmp) {
-					return
-				}
-				continue
-			}
-			if err == nil {
-				if length > 0 && length < len(tmp) {
-					return
-				}
-			} else if errors.Is(err, io.EOF) {
-				return
-			} else {
-				gologger.Debug().Msgf("Read Error:%v", err)
-				if len(conStatus.data) == 0 {
-					conStatus.status = StatusReadTimeout
-				}
+			if length < len(tmp) {
 				return
 			}
+			continue
+		}
+		if err == nil {
+			continue
+		} else if errors.Is(err, io.EOF) {
+			return
+		} else {
+			logger.Debugf("Read Error:%v", err)
+			conStatus.err = err
+			if len(conStatus.data) == 0 {
+				conStatus.status = StatusReadTimeout
+			}
+			return
 		}
 	}
 }
 
-func udpSend(remoteAddr *net.UDPAddr, data []byte, timeout time.Duration) ([]byte, error) {
-	conn, err := net.DialUDP("udp", nil, remoteAddr)
+func udpSend(ctx context.Context, remoteAddr *net.UDPAddr, sourcePort int, data []byte, timeout time.Duration) ([]byte, error) {
+	var conn *net.UDPConn
+	var err error
+	// 探针要求的源端口可能需要特权或已被占用 失败时退回随机端口
+	if sourcePort > 0 {
+		conn, err = net.DialUDP("udp", &net.UDPAddr{Port: sourcePort}, remoteAddr)
+	}
+	if conn == nil {
+		conn, err = net.DialUDP("udp", nil, remoteAddr)
+	}
 	if err != nil {
-		return nil, errors.New(err.Error() + " STEP1:CONNECT")
+		return nil, err
 	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Now())
+	})
+	defer stop()
 	_, err = conn.Write(data)
 	if err != nil {
 		return nil, err
@@ -360,10 +551,7 @@ func udpSend(remoteAddr *net.UDPAddr, data []byte, timeout time.Duration) ([]byt
 		}
 		n, _, err := conn.ReadFromUDP(tmp)
 		if err != nil {
-			var netErr net.Error
-			if errors.As(err, &netErr) && netErr.Timeout() {
-				return nil, errors.New(err.Error() + " STEP3:READ")
-			}
+			return buf, err
 		}
 		buf = append(buf, tmp[:n]...)
 		if n < len(tmp) {
@@ -373,6 +561,51 @@ func udpSend(remoteAddr *net.UDPAddr, data []byte, timeout time.Duration) ([]byt
 	return buf, nil
 }
 
+// tcpProbes 按强度和单次扫描参数挑选并排序 TCP 探针
+// 已经使用 TLS 时不再发送用于识别 TLS 的探针
+func (cfg *scanConfig) tcpProbes(db *probeDB, port int, ssl bool) []*probe {
+	probes := cfg.selectProbes(db.tcpProbes, port, ssl)
+	if ssl {
+		var result []*probe
+		for _, pb := range probes {
+			if !isTlsProbe(pb) {
+				result = append(result, pb)
+			}
+		}
+		probes = result
+	}
+	return cfg.sortProbes(probes, port, ssl)
+}
+
+// sortProbes 限制探针数量时优先发送最可能得到响应的探针
+func (cfg *scanConfig) sortProbes(probes []*probe, port int, ssl bool) []*probe {
+	if cfg.maxProbes > 0 {
+		return budgetSort(probes, port, ssl)
+	}
+	return sortProbes(probes, port, ssl)
+}
+
+func isTlsProbe(pb *probe) bool {
+	return pb.Name == "TLSSessionReq" || pb.Name == "SSLSessionReq"
+}
+
+// guessService 标记服务名称的来源 没有探针匹配时按端口服务表给出低可信度的猜测
+func guessService(response *Response, port int) {
+	switch response.Status {
+	case StatusMatched:
+		response.Method = MethodProbed
+		if response.Confidence == 0 {
+			response.Confidence = confidenceProbed
+		}
+	case StatusUnknown:
+		if name, ok := LookupService(port, response.Protocol); ok {
+			response.Service = &MatchResult{Service: name}
+			response.Method = MethodTable
+			response.Confidence = confidenceTable
+		}
+	}
+}
+
 func fixServiceName(serviceName string, ssl bool) string {
 	if ssl && serviceName == "http" {
 		return "https"
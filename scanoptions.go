@@ -0,0 +1,164 @@
+package gonmap
+
+import (
+	"context"
+	"net"
+	"strconv"
+)
+
+// TLSMode 探测时是否使用 TLS
+type TLSMode string
+
+const (
+	// TLSAuto 根据 SSLSessionReq/TLSSessionReq 的匹配结果自动切换到 TLS
+	TLSAuto TLSMode = ""
+	// TLSOn 直接通过 TLS 发送所有探针
+	TLSOn TLSMode = "on"
+	// TLSOff 从不切换到 TLS
+	TLSOff TLSMode = "off"
+)
+
+const defaultMaxBannerSize = 4096
+
+// ScanOptions 单次扫描的参数 覆盖 Options 中的默认值
+// 使用 Nmap.DefaultScanOptions 获取默认值后按需修改
+type ScanOptions struct {
+	// 版本探测强度 0-9 为空时使用 Options.VersionIntensity 指向 0 时只发送 NULL 和端口匹配的探针
+	VersionIntensity *int
+	// 只发送这些探针 为空时不限制
+	Probes []string
+	// 不发送这些探针
+	ExcludeProbes []string
+	TLS           TLSMode
+	// TLS SNI 以及探针中 {Host} 使用的主机名 为空时使用目标 IP
+	ServerName string
+	// 为零的字段使用 Options 中的值
+	Timeouts Timeouts
+	// 单个探针读取响应的最大字节数
+	MaxBannerSize int
+	// 大于 0 时每个端口最多发送的探针数 包括 NULL 探针
+	// 此时先发送 NULL 探针和最适合该端口的探针 用完后返回已收到的 Banner
+	MaxProbes int
+	// 本次扫描的日志 为空时使用 Options.Logger
+	Logger Logger
+	// 识别到 http/https 后获取标题 响应头和 favicon 等页面信息 为空时不获取
+	HTTP *HTTPOptions
+	// 端口使用 TLS 时计算 JARM 和 JA3S 需要额外建立 10 个连接
+	TLSFingerprint bool
+	// 识别到 ssh 后完成 KEXINIT 交换 记录算法和 HASSH 为空时不交换
+	SSH *SSHOptions
+}
+
+// DefaultScanOptions 返回由 Options 得到的默认扫描参数
+func (n *Nmap) DefaultScanOptions() *ScanOptions {
+	return &ScanOptions{
+		Timeouts:      n.option.timeouts(),
+		MaxBannerSize: defaultMaxBannerSize,
+	}
+}
+
+// ScanWithOptions 使用单次扫描参数扫描目标 options 为空时使用默认参数
+// 同一个 Nmap 可以同时处理不同参数的扫描
+func (n *Nmap) ScanWithOptions(ctx context.Context, protocol Protocol, ip string, port int, options *ScanOptions) *Response {
+	return n.scan(ctx, protocol, ip, port, n.scanConfig(options))
+}
+
+// scanConfig 将 ScanOptions 转换为扫描配置
+func (n *Nmap) scanConfig(options *ScanOptions) *scanConfig {
+	cfg := n.defaultScanConfig()
+	if options == nil {
+		return cfg
+	}
+	if options.VersionIntensity != nil {
+		cfg.intensity = clampIntensity(*options.VersionIntensity)
+	}
+	if options.Timeouts.Connect > 0 {
+		cfg.timeouts.Connect = options.Timeouts.Connect
+	}
+	if options.Timeouts.Read > 0 {
+		cfg.timeouts.Read = options.Timeouts.Read
+	}
+	if options.Timeouts.Port > 0 {
+		cfg.timeouts.Port = options.Timeouts.Port
+	}
+	if options.Timeouts.Host > 0 {
+		cfg.timeouts.Host = options.Timeouts.Host
+	}
+	if len(options.Probes) > 0 {
+		cfg.allowProbes = make(map[string]struct{}, len(options.Probes))
+		for _, name := range options.Probes {
+			cfg.allowProbes[name] = struct{}{}
+		}
+	}
+	if len(options.ExcludeProbes) > 0 {
+		cfg.denyProbes = make(map[string]struct{}, len(options.ExcludeProbes))
+		for _, name := range options.ExcludeProbes {
+			cfg.denyProbes[name] = struct{}{}
+		}
+	}
+	cfg.tls = options.TLS
+	cfg.serverName = options.ServerName
+	if options.MaxBannerSize > 0 {
+		cfg.maxBannerSize = options.MaxBannerSize
+	}
+	cfg.maxProbes = options.MaxProbes
+	if options.Logger != nil {
+		cfg.logger = options.Logger
+	}
+	cfg.tlsFingerprint = options.TLSFingerprint
+	if options.SSH != nil {
+		sshOptions := *options.SSH
+		cfg.ssh = &sshOptions
+	}
+	if options.HTTP != nil {
+		httpOptions := *options.HTTP
+		cfg.http = &httpOptions
+	}
+	return cfg
+}
+
+// selectProbes 按强度 验证的服务以及探针白名单和黑名单挑选探针
+func (cfg *scanConfig) selectProbes(probes []*probe, port int, ssl bool) []*probe {
+	if cfg.expect == nil {
+		return cfg.filterProbes(selectProbes(probes, port, ssl, cfg.intensity))
+	}
+	// 验证模式只看探针能否识别期望的服务 不受强度限制
+	var selected []*probe
+	for _, pb := range probes {
+		if pb.identifies(cfg.expect) || (!ssl && cfg.tls == TLSAuto && isTlsProbe(pb)) {
+			selected = append(selected, pb)
+		}
+	}
+	return cfg.filterProbes(selected)
+}
+
+// filterProbes 按照探针白名单和黑名单过滤
+func (cfg *scanConfig) filterProbes(probes []*probe) []*probe {
+	if cfg.allowProbes == nil && cfg.denyProbes == nil {
+		return probes
+	}
+	var result []*probe
+	for _, pb := range probes {
+		if cfg.allowProbes != nil {
+			if _, ok := cfg.allowProbes[pb.Name]; !ok {
+				continue
+			}
+		}
+		if _, ok := cfg.denyProbes[pb.Name]; ok {
+			continue
+		}
+		result = append(result, pb)
+	}
+	return result
+}
+
+// hostValue 探针中 {Host} 的替换值 默认为 ip:port IPv6 地址加方括号
+func (cfg *scanConfig) hostValue(ip string, port int) string {
+	if cfg.serverName == "" {
+		return net.JoinHostPort(ip, strconv.Itoa(port))
+	}
+	if port == 80 || port == 443 {
+		return cfg.serverName
+	}
+	return net.JoinHostPort(cfg.serverName, strconv.Itoa(port))
+}
@@ -0,0 +1,218 @@
+package gonmap
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScanWithOptions(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte("SSH-2.0-OpenSSH_8.9p1 Ubuntu-3\r\n"))
+			_ = conn.Close()
+		}
+	}()
+	port := listener.Addr().(*net.TCPAddr).Port
+	n := New(&Options{VersionIntensity: 7, Timeout: 1})
+
+	options := n.DefaultScanOptions()
+	options.Probes = []string{"NULL"}
+	options.Timeouts.Read = time.Second
+	response := n.ScanWithOptions(context.Background(), TCP, "127.0.0.1", port, options)
+	if assert.Equal(t, StatusMatched, response.Status) {
+		assert.Equal(t, "ssh", response.Service.Service)
+	}
+	// 读取长度限制后无法匹配
+	options.MaxBannerSize = 2
+	response = n.ScanWithOptions(context.Background(), TCP, "127.0.0.1", port, options)
+	assert.Equal(t, StatusUnknown, response.Status)
+
+	intensity := 9
+	cfg := n.scanConfig(&ScanOptions{VersionIntensity: &intensity, ExcludeProbes: []string{"NULL"}, ServerName: "example.com"})
+	for _, pb := range cfg.tcpProbes(n.probeDB(), 443, true) {
+		assert.NotEqual(t, "NULL", pb.Name)
+		assert.False(t, isTlsProbe(pb))
+	}
+	assert.Equal(t, "example.com", cfg.hostValue("127.0.0.1", 443))
+	assert.Equal(t, "example.com:8443", cfg.hostValue("127.0.0.1", 8443))
+
+	// 零值的 ScanOptions 使用 Options 中的强度 显式指定 0 时只发送 NULL 和端口匹配的探针
+	assert.Equal(t, 7, n.scanConfig(&ScanOptions{}).intensity)
+	assert.Equal(t, 7, n.scanConfig(n.DefaultScanOptions()).intensity)
+	intensity = 0
+	cfg = n.scanConfig(&ScanOptions{VersionIntensity: &intensity})
+	assert.Equal(t, 0, cfg.intensity)
+	for _, pb := range cfg.tcpProbes(n.probeDB(), 12345, false) {
+		assert.True(t, pb.Name == "NULL" || pb.ports.exist(12345), pb.Name)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte("SSH-2.0-OpenSSH_8.9p1 Ubuntu-3\r\n"))
+			_ = conn.Close()
+		}
+	}()
+	port := listener.Addr().(*net.TCPAddr).Port
+	n := New(&Options{VersionIntensity: 7, Timeout: 1})
+	options := n.DefaultScanOptions()
+	options.Timeouts.Read = time.Second
+
+	cfg := n.scanConfig(options)
+	cfg.expect = expectServices([]string{"ssh"})
+	for _, pb := range cfg.tcpProbes(n.probeDB(), port, false) {
+		assert.True(t, isTlsProbe(pb) || pb.identifies(cfg.expect))
+	}
+	assert.Equal(t, VerdictConfirmed, n.Verify(context.Background(), TCP, "127.0.0.1", port, []string{"ssh"}, options).Verdict)
+	response := n.Verify(context.Background(), TCP, "127.0.0.1", port, []string{"ftp"}, options)
+	if assert.Equal(t, VerdictMismatched, response.Verdict) {
+		assert.Equal(t, "ssh", response.Service.Service)
+	}
+}
+
+func TestMaxProbes(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte("xyzzy plugh\r\n"))
+			_ = conn.Close()
+		}
+	}()
+	port := listener.Addr().(*net.TCPAddr).Port
+	n := New(&Options{VersionIntensity: 7, Timeout: 1})
+	options := n.DefaultScanOptions()
+	options.Timeouts.Read = time.Second
+	options.MaxProbes = 2
+	response := n.ScanWithOptions(context.Background(), TCP, "127.0.0.1", port, options)
+	assert.Equal(t, StatusUnknown, response.Status)
+	assert.True(t, response.Truncated)
+	assert.Equal(t, []byte("xyzzy plugh\r\n"), response.Banner)
+
+	sorted := budgetSort(selectProbes(n.GetTcpProbe(), 80, false, 7), 80, false)
+	assert.Equal(t, "NULL", sorted[0].Name)
+	assert.Equal(t, "GetRequest", sorted[1].Name)
+}
+
+func TestNoPayloadVersionScan(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			size, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if string(buf[:size]) == "\x02" {
+				_, _ = conn.WriteTo([]byte("\x05\x40\x00ServerName;SQLSRV;InstanceName;MSSQLSERVER;IsClustered;No;Version;15.0.2000.5;;"), addr)
+			}
+		}
+	}()
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	n := New(&Options{VersionIntensity: 7, Timeout: 1})
+
+	// no-payload 只影响端口扫描 版本扫描时未声明的端口也会发送 Sqlping
+	options := n.DefaultScanOptions()
+	options.Probes = []string{"Sqlping"}
+	options.Timeouts.Read = time.Second
+	response := n.ScanWithOptions(context.Background(), UDP, "127.0.0.1", port, options)
+	if assert.Equal(t, StatusMatched, response.Status) {
+		assert.Equal(t, "ms-sql-m", response.Service.Service)
+		assert.Equal(t, "15.0.2000.5", response.Service.Version)
+	}
+}
+
+func TestSoftMatchContinue(t *testing.T) {
+	// 连接后等待请求 收到 VERSION 时返回版本 否则只发送 banner
+	serve := func(version bool) int {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = listener.Close() })
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					defer conn.Close()
+					buf := make([]byte, 64)
+					_ = conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+					size, _ := conn.Read(buf)
+					if version && string(buf[:size]) == "VERSION\r\n" {
+						_, _ = conn.Write([]byte("INHOUSE READY 2.0\r\n"))
+						return
+					}
+					_, _ = conn.Write([]byte("INHOUSE READY\r\n"))
+				}()
+			}
+		}()
+		return listener.Addr().(*net.TCPAddr).Port
+	}
+	n, err := NewNmap(&Options{VersionIntensity: 7, Timeout: 1})
+	assert.NoError(t, err)
+	assert.NoError(t, n.AddMatch(TCP, "NULL", MatchSpec{Service: "inhouse", Pattern: `^INHOUSE READY`, Soft: true}))
+	assert.NoError(t, n.RegisterProbe(ProbeSpec{
+		Name:     "InHouseVersion",
+		Protocol: TCP,
+		Payload:  []byte("VERSION\r\n"),
+		Rarity:   3,
+		Rules: []MatchSpec{
+			{Service: "inhouse", Pattern: `^INHOUSE READY ([\d.]+)`, Version: "$1"},
+			{Service: "inhouse", Pattern: `^INHOUSE READY\r\n`, Soft: true},
+		},
+	}))
+	options := n.DefaultScanOptions()
+	options.Probes = []string{"NULL", "InHouseVersion"}
+	options.Timeouts.Read = time.Second
+
+	// softmatch 之后继续发送同一服务的探针 得到硬匹配时返回硬匹配
+	response := n.ScanWithOptions(context.Background(), TCP, "127.0.0.1", serve(true), options)
+	if assert.Equal(t, StatusMatched, response.Status) {
+		assert.False(t, response.Service.Soft)
+		assert.Equal(t, "2.0", response.Service.Version)
+	}
+	// 没有硬匹配时返回 softmatch 其他探针也得到同一服务时提高可信度
+	response = n.ScanWithOptions(context.Background(), TCP, "127.0.0.1", serve(false), options)
+	if assert.Equal(t, StatusMatched, response.Status) {
+		assert.True(t, response.Service.Soft)
+		assert.Equal(t, "inhouse", response.Service.Service)
+		assert.Equal(t, matchConfidence(response.Service, false, false, 1), response.Confidence)
+	}
+}
@@ -0,0 +1,81 @@
+package gonmap
+
+import (
+	"bufio"
+	_ "embed"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//go:embed nmap-services
+var nmapServices string
+
+// serviceEntry nmap-services 中的一条记录
+type serviceEntry struct {
+	Name      string
+	Port      int
+	Protocol  Protocol
+	Frequency float64
+}
+
+var (
+	serviceTableOnce sync.Once
+	serviceTable     []serviceEntry
+)
+
+// loadServiceTable 解析 nmap-services 格式的端口服务表 开放频率可以省略
+func loadServiceTable(s string) []serviceEntry {
+	var entries []serviceEntry
+	scanner := bufio.NewScanner(strings.NewReader(s))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		portProto := strings.SplitN(fields[1], "/", 2)
+		if len(portProto) != 2 {
+			continue
+		}
+		port, err := strconv.Atoi(portProto[0])
+		if err != nil || port < 1 || port > 65535 {
+			continue
+		}
+		entry := serviceEntry{Name: fields[0], Port: port, Protocol: Protocol(strings.ToUpper(portProto[1]))}
+		if len(fields) > 2 {
+			entry.Frequency, _ = strconv.ParseFloat(fields[2], 64)
+		}
+		entries = append(entries, entry)
+	}
+	// 按照开放频率降序排列 没有频率时保持文件中的顺序
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Frequency > entries[j].Frequency
+	})
+	return entries
+}
+
+func getServiceTable() []serviceEntry {
+	serviceTableOnce.Do(func() {
+		serviceTable = loadServiceTable(nmapServices)
+	})
+	return serviceTable
+}
+
+// LookupService 按照 nmap-services 查找端口对应的常见服务 同一端口有多个服务时取开放频率最高的
+// 表中名称为 unknown 的端口与 nmap 一样没有可猜测的服务
+func LookupService(port int, protocol Protocol) (string, bool) {
+	for _, entry := range getServiceTable() {
+		if entry.Port == port && entry.Protocol == protocol {
+			if entry.Name == "unknown" {
+				return "", false
+			}
+			return FixProtocol(entry.Name), true
+		}
+	}
+	return "", false
+}
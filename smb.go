@@ -0,0 +1,339 @@
+package gonmap
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	smb2CommandNegotiate    = 0
+	smb2CommandSessionSetup = 1
+	// STATUS_MORE_PROCESSING_REQUIRED
+	smbStatusMoreProcessing = 0xc0000016
+	// 单个 SMB 报文的最大长度
+	maxSMBPacket = 64 * 1024
+)
+
+var errSMBResponse = errors.New("smb2 response malformed")
+
+var smb2Magic = []byte{0xfe, 'S', 'M', 'B'}
+
+// smbDialects 按版本从低到高排列
+var smbDialects = []struct {
+	code uint16
+	name string
+}{
+	{0x0202, "2.0.2"},
+	{0x0210, "2.1"},
+	{0x0300, "3.0"},
+	{0x0302, "3.0.2"},
+	{0x0311, "3.1.1"},
+}
+
+// smbCapabilities NEGOTIATE 响应中的 Capabilities 位
+var smbCapabilities = []struct {
+	flag uint32
+	name string
+}{
+	{0x01, "DFS"},
+	{0x02, "LEASING"},
+	{0x04, "LARGE_MTU"},
+	{0x08, "MULTI_CHANNEL"},
+	{0x10, "PERSISTENT_HANDLES"},
+	{0x20, "DIRECTORY_LEASING"},
+	{0x40, "ENCRYPTION"},
+}
+
+// SMBInfo SMB2/3 NEGOTIATE 和匿名 SESSION_SETUP 得到的信息
+type SMBInfo struct {
+	// 服务端支持的 SMB2/3 方言 例如 2.1 3.1.1
+	Dialects []string `json:"dialects"`
+	// 同时提供所有方言时服务端选择的方言
+	Dialect         string `json:"dialect"`
+	SigningEnabled  bool   `json:"signing_enabled"`
+	SigningRequired bool   `json:"signing_required"`
+	ServerGUID      string `json:"server_guid"`
+	// 服务端时间 服务端不提供时为 nil
+	SystemTime   *time.Time `json:"system_time,omitempty"`
+	Capabilities []string   `json:"capabilities,omitempty"`
+	// NTLM CHALLENGE 中的计算机名 域名和系统版本
+	NTLM *NTLMInfo `json:"ntlm,omitempty"`
+}
+
+// smbNegotiation NEGOTIATE 响应中的字段
+type smbNegotiation struct {
+	securityMode uint16
+	dialect      uint16
+	guid         []byte
+	capabilities uint32
+	systemTime   uint64
+	response     []byte
+}
+
+// smbDetector 发送 SMB2 NEGOTIATE 逐个测试方言 并通过 NTLMSSP 获取主机信息
+type smbDetector struct{}
+
+func (smbDetector) Name() string       { return "SMB2Negotiate" }
+func (smbDetector) Protocol() Protocol { return TCP }
+func (smbDetector) Ports() []int       { return []int{445} }
+func (smbDetector) Services() []string { return []string{"microsoft-ds"} }
+func (smbDetector) Rarity() int        { return 9 }
+
+func (smbDetector) Detect(ctx context.Context, conn net.Conn, target Target) (*MatchResult, error) {
+	all := make([]uint16, 0, len(smbDialects))
+	for _, dialect := range smbDialects {
+		all = append(all, dialect.code)
+	}
+	negotiation, err := smbNegotiate(conn, all)
+	if negotiation == nil {
+		return nil, err
+	}
+	info := &SMBInfo{
+		Dialect:         smbDialectName(negotiation.dialect),
+		SigningEnabled:  negotiation.securityMode&0x01 != 0,
+		SigningRequired: negotiation.securityMode&0x02 != 0,
+		ServerGUID:      formatGUID(negotiation.guid),
+		SystemTime:      fileTime(negotiation.systemTime),
+	}
+	for _, capability := range smbCapabilities {
+		if negotiation.capabilities&capability.flag != 0 {
+			info.Capabilities = append(info.Capabilities, capability.name)
+		}
+	}
+	for _, dialect := range smbDialects {
+		if dialect.code == negotiation.dialect {
+			info.Dialects = append(info.Dialects, dialect.name)
+			continue
+		}
+		// 服务端选择最高的方言 高于它的方言不支持
+		if dialect.code > negotiation.dialect || target.Dial == nil || ctx.Err() != nil {
+			continue
+		}
+		if smbSupports(ctx, target, dialect.code) {
+			info.Dialects = append(info.Dialects, dialect.name)
+		}
+	}
+	result := &MatchResult{Service: "microsoft-ds", Response: negotiation.response}
+	ntlm, err := smbSessionSetup(conn)
+	info.NTLM = ntlm
+	return smbResult(result, info), err
+}
+
+// smbResult 根据方言 签名和 NTLM 信息填写识别结果
+func smbResult(result *MatchResult, info *SMBInfo) *MatchResult {
+	signing := "signing disabled"
+	if info.SigningRequired {
+		signing = "signing required"
+	} else if info.SigningEnabled {
+		signing = "signing enabled"
+	}
+	result.Info = fmt.Sprintf("dialects: %s; %s", strings.Join(info.Dialects, ", "), signing)
+	if info.NTLM != nil {
+		result.Version = info.NTLM.OSVersion
+		result.Hostname = info.NTLM.NetBIOSComputerName
+		// Samba 的 NTLM 版本号中 build 为 0
+		if info.NTLM.OSVersion != "" && !strings.HasSuffix(info.NTLM.OSVersion, ".0") {
+			result.OS = "Windows"
+			result.CPE = []string{"cpe:/o:microsoft:windows"}
+		}
+	}
+	result.Details = info
+	return result
+}
+
+// smbSupports 使用新连接只提供一种方言 服务端接受时返回 true
+func smbSupports(ctx context.Context, target Target, dialect uint16) bool {
+	conn, err := target.Dial(ctx)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	negotiation, _ := smbNegotiate(conn, []uint16{dialect})
+	return negotiation != nil && negotiation.dialect == dialect
+}
+
+// smbDialectName 返回方言的版本号
+func smbDialectName(code uint16) string {
+	for _, dialect := range smbDialects {
+		if dialect.code == code {
+			return dialect.name
+		}
+	}
+	return fmt.Sprintf("0x%04x", code)
+}
+
+// formatGUID 按 Windows 的混合字节序格式化 GUID
+func formatGUID(b []byte) string {
+	if len(b) != 16 {
+		return ""
+	}
+	return fmt.Sprintf("%08x-%04x-%04x-%x-%x",
+		binary.LittleEndian.Uint32(b), binary.LittleEndian.Uint16(b[4:]), binary.LittleEndian.Uint16(b[6:]), b[8:10], b[10:])
+}
+
+// smb2Header 构造 64 字节的 SMB2 同步报文头
+func smb2Header(command uint16, messageID uint64) []byte {
+	header := append([]byte(nil), smb2Magic...)
+	header = binary.LittleEndian.AppendUint16(header, 64)
+	// CreditCharge Status
+	header = append(header, 0, 0, 0, 0, 0, 0)
+	header = binary.LittleEndian.AppendUint16(header, command)
+	// CreditRequest
+	header = binary.LittleEndian.AppendUint16(header, 1)
+	// Flags NextCommand
+	header = append(header, make([]byte, 8)...)
+	header = binary.LittleEndian.AppendUint64(header, messageID)
+	// Reserved TreeId SessionId Signature
+	return append(header, make([]byte, 32)...)
+}
+
+// smbNegotiateRequest 构造 NEGOTIATE 请求 包含 3.1.1 时附加 PREAUTH 和 ENCRYPTION 上下文
+func smbNegotiateRequest(dialects []uint16) []byte {
+	packet := smb2Header(smb2CommandNegotiate, 0)
+	body := binary.LittleEndian.AppendUint16(nil, 36)
+	body = binary.LittleEndian.AppendUint16(body, uint16(len(dialects)))
+	// SecurityMode 支持签名 Reserved
+	body = append(body, 0x01, 0x00, 0x00, 0x00)
+	// Capabilities
+	body = binary.LittleEndian.AppendUint32(body, 0x7f)
+	body = append(body, randomBytes(16)...)
+	// NegotiateContextOffset NegotiateContextCount Reserved2 之后填写
+	contextField := len(packet) + len(body)
+	body = append(body, make([]byte, 8)...)
+	for _, dialect := range dialects {
+		body = binary.LittleEndian.AppendUint16(body, dialect)
+	}
+	packet = append(packet, body...)
+	for _, dialect := range dialects {
+		if dialect != 0x0311 {
+			continue
+		}
+		for len(packet)%8 != 0 {
+			packet = append(packet, 0)
+		}
+		binary.LittleEndian.PutUint32(packet[contextField:], uint32(len(packet)))
+		binary.LittleEndian.PutUint16(packet[contextField+4:], 2)
+		// SMB2_PREAUTH_INTEGRITY_CAPABILITIES SHA-512 32 字节 salt
+		preauth := []byte{0x01, 0x00, 0x20, 0x00, 0x01, 0x00}
+		preauth = append(preauth, randomBytes(32)...)
+		packet = append(packet, smbNegotiateContext(1, preauth)...)
+		for len(packet)%8 != 0 {
+			packet = append(packet, 0)
+		}
+		// SMB2_ENCRYPTION_CAPABILITIES AES-128-GCM AES-128-CCM
+		packet = append(packet, smbNegotiateContext(2, []byte{0x02, 0x00, 0x02, 0x00, 0x01, 0x00})...)
+	}
+	return netBIOSSession(packet)
+}
+
+// smbNegotiateContext 构造一个 NEGOTIATE 上下文
+func smbNegotiateContext(contextType uint16, data []byte) []byte {
+	header := binary.LittleEndian.AppendUint16(nil, contextType)
+	header = binary.LittleEndian.AppendUint16(header, uint16(len(data)))
+	header = append(header, 0, 0, 0, 0)
+	return append(header, data...)
+}
+
+// netBIOSSession 加上直连 TCP 的 4 字节 NetBIOS 会话头
+func netBIOSSession(packet []byte) []byte {
+	length := len(packet)
+	return append([]byte{0x00, byte(length >> 16), byte(length >> 8), byte(length)}, packet...)
+}
+
+// readSMBPacket 读取一个 NetBIOS 会话报文 返回 SMB2 报文头以及之后的数据
+func readSMBPacket(conn net.Conn) ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	length := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+	if header[0] != 0x00 || length < 64 || length > maxSMBPacket {
+		return nil, errSMBResponse
+	}
+	packet := make([]byte, length)
+	if _, err := io.ReadFull(conn, packet); err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(packet, smb2Magic) {
+		return nil, errSMBResponse
+	}
+	return packet, nil
+}
+
+// smbNegotiate 发送 NEGOTIATE 并解析响应 响应不是 SMB2 NEGOTIATE 时返回 nil
+func smbNegotiate(conn net.Conn, dialects []uint16) (*smbNegotiation, error) {
+	if _, err := conn.Write(smbNegotiateRequest(dialects)); err != nil {
+		return nil, err
+	}
+	packet, err := readSMBPacket(conn)
+	if err != nil {
+		if errors.Is(err, errSMBResponse) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	status := binary.LittleEndian.Uint32(packet[8:])
+	command := binary.LittleEndian.Uint16(packet[12:])
+	body := packet[64:]
+	// 固定部分 64 字节 StructureSize 为 65
+	if status != 0 || command != smb2CommandNegotiate || len(body) < 64 || binary.LittleEndian.Uint16(body) != 65 {
+		return nil, nil
+	}
+	return &smbNegotiation{
+		securityMode: binary.LittleEndian.Uint16(body[2:]),
+		dialect:      binary.LittleEndian.Uint16(body[4:]),
+		guid:         append([]byte(nil), body[8:24]...),
+		capabilities: binary.LittleEndian.Uint32(body[24:]),
+		systemTime:   binary.LittleEndian.Uint64(body[40:]),
+		response:     append([]byte(nil), packet...),
+	}, nil
+}
+
+// spnegoNegotiate 将 NTLM NEGOTIATE 包装为 SPNEGO NegTokenInit
+func spnegoNegotiate(token []byte) []byte {
+	spnegoOID := []byte{0x06, 0x06, 0x2b, 0x06, 0x01, 0x05, 0x05, 0x02}
+	ntlmOID := []byte{0x06, 0x0a, 0x2b, 0x06, 0x01, 0x04, 0x01, 0x82, 0x37, 0x02, 0x02, 0x0a}
+	mechTypes := derTLV(0xa0, derTLV(0x30, ntlmOID))
+	mechToken := derTLV(0xa2, derTLV(0x04, token))
+	negTokenInit := derTLV(0xa0, derTLV(0x30, append(mechTypes, mechToken...)))
+	return derTLV(0x60, append(spnegoOID, negTokenInit...))
+}
+
+// smbSessionSetup 发送匿名 SESSION_SETUP 中的 NTLM NEGOTIATE 解析服务端的 CHALLENGE 不完成认证
+func smbSessionSetup(conn net.Conn) (*NTLMInfo, error) {
+	blob := spnegoNegotiate(ntlmNegotiate)
+	packet := smb2Header(smb2CommandSessionSetup, 1)
+	body := binary.LittleEndian.AppendUint16(nil, 25)
+	// Flags SecurityMode Capabilities Channel
+	body = append(body, 0x00, 0x01, 0, 0, 0, 0, 0, 0, 0, 0)
+	body = binary.LittleEndian.AppendUint16(body, uint16(64+24))
+	body = binary.LittleEndian.AppendUint16(body, uint16(len(blob)))
+	// PreviousSessionId
+	body = append(body, make([]byte, 8)...)
+	packet = append(append(packet, body...), blob...)
+	if _, err := conn.Write(netBIOSSession(packet)); err != nil {
+		return nil, err
+	}
+	response, err := readSMBPacket(conn)
+	if err != nil {
+		return nil, err
+	}
+	if status := binary.LittleEndian.Uint32(response[8:]); status != smbStatusMoreProcessing {
+		return nil, fmt.Errorf("smb2 session setup status 0x%08x", status)
+	}
+	return parseNTLMChallenge(response[64:])
+}
+
+func init() {
+	mustRegisterDetector(smbDetector{})
+}
@@ -0,0 +1,124 @@
+package gonmap
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serveSMB 模拟支持 2.1 3.0 3.1.1 并要求签名的 SMB 服务
+func serveSMB(t *testing.T, guid []byte, systemTime uint64, challenge []byte) int {
+	supported := map[uint16]bool{0x0210: true, 0x0300: true, 0x0311: true}
+	reply := func(command uint16, status uint32, body []byte) []byte {
+		packet := smb2Header(command, 0)
+		binary.LittleEndian.PutUint32(packet[8:], status)
+		return netBIOSSession(append(packet, body...))
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+				for {
+					packet, err := readSMBPacket(conn)
+					if err != nil {
+						return
+					}
+					switch binary.LittleEndian.Uint16(packet[12:]) {
+					case smb2CommandNegotiate:
+						body := packet[64:]
+						count := int(binary.LittleEndian.Uint16(body[2:]))
+						var selected uint16
+						for i := 0; i < count; i++ {
+							dialect := binary.LittleEndian.Uint16(body[36+i*2:])
+							if supported[dialect] && dialect > selected {
+								selected = dialect
+							}
+						}
+						if selected == 0 {
+							// STATUS_NOT_SUPPORTED
+							_, _ = conn.Write(reply(smb2CommandNegotiate, 0xc00000bb, []byte{0x09, 0x00, 0, 0, 0, 0, 0, 0, 0}))
+							return
+						}
+						response := binary.LittleEndian.AppendUint16(nil, 65)
+						// 支持并要求签名
+						response = binary.LittleEndian.AppendUint16(response, 0x03)
+						response = binary.LittleEndian.AppendUint16(response, selected)
+						response = append(response, 0, 0)
+						response = append(response, guid...)
+						// DFS LEASING LARGE_MTU
+						response = binary.LittleEndian.AppendUint32(response, 0x07)
+						response = append(response, make([]byte, 12)...)
+						response = binary.LittleEndian.AppendUint64(response, systemTime)
+						response = append(response, make([]byte, 16)...)
+						_, _ = conn.Write(reply(smb2CommandNegotiate, 0, response))
+					case smb2CommandSessionSetup:
+						response := []byte{0x09, 0x00, 0x00, 0x00, 0x48, 0x00}
+						response = binary.LittleEndian.AppendUint16(response, uint16(len(challenge)))
+						_, _ = conn.Write(reply(smb2CommandSessionSetup, smbStatusMoreProcessing, append(response, challenge...)))
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestSMBDetector(t *testing.T) {
+	guid := []byte{0x78, 0x56, 0x34, 0x12, 0x34, 0x12, 0x34, 0x12, 0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	systemTime := uint64(now.UnixNano()/100) + 116444736000000000
+	challenge := ntlmChallengeMessage(map[uint16]string{1: "FILESRV", 2: "CORP", 3: "filesrv.corp.local", 4: "corp.local"}, 10, 0, 20348)
+	port := serveSMB(t, guid, systemTime, challenge)
+
+	n := New(&Options{VersionIntensity: 9, Timeout: 1})
+	options := n.DefaultScanOptions()
+	options.Probes = []string{"SMB2Negotiate"}
+	options.Timeouts.Read = time.Second
+	response := n.ScanWithOptions(context.Background(), TCP, "127.0.0.1", port, options)
+	if assert.Equal(t, StatusMatched, response.Status) {
+		assert.Equal(t, "smb", response.Service.Service)
+		assert.Equal(t, "10.0.20348", response.Service.Version)
+		assert.Equal(t, "FILESRV", response.Service.Hostname)
+		assert.Equal(t, "Windows", response.Service.OS)
+		assert.Equal(t, "dialects: 2.1, 3.0, 3.1.1; signing required", response.Service.Info)
+		if assert.NotNil(t, response.SMB) {
+			info := response.SMB
+			assert.Equal(t, []string{"2.1", "3.0", "3.1.1"}, info.Dialects)
+			assert.Equal(t, "3.1.1", info.Dialect)
+			assert.True(t, info.SigningEnabled)
+			assert.True(t, info.SigningRequired)
+			assert.Equal(t, "12345678-1234-1234-1234-56789abcdef0", info.ServerGUID)
+			if assert.NotNil(t, info.SystemTime) {
+				assert.True(t, now.Equal(*info.SystemTime))
+			}
+			assert.Equal(t, []string{"DFS", "LEASING", "LARGE_MTU"}, info.Capabilities)
+			if assert.NotNil(t, info.NTLM) {
+				assert.Equal(t, "CORP", info.NTLM.NetBIOSDomainName)
+				assert.Equal(t, "filesrv.corp.local", info.NTLM.DNSComputerName)
+				assert.Equal(t, "corp.local", info.NTLM.DNSDomainName)
+			}
+		}
+	}
+
+	// 3.1.1 请求中的上下文按 8 字节对齐
+	request := smbNegotiateRequest([]uint16{0x0311})[4:]
+	offset := binary.LittleEndian.Uint32(request[64+28:])
+	assert.Equal(t, uint32(0), offset%8)
+	assert.Equal(t, uint16(2), binary.LittleEndian.Uint16(request[64+32:]))
+	assert.Equal(t, uint16(1), binary.LittleEndian.Uint16(request[offset:]))
+}
@@ -0,0 +1,304 @@
+package gonmap
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/proxy"
+)
+
+const (
+	sshClientVersion = "SSH-2.0-gonmap"
+	sshMsgKexInit    = 20
+	// 单个 SSH 报文的最大长度 RFC 4253 要求至少支持 35000
+	maxSSHPacket = 35000
+)
+
+var errSSHKexInit = errors.New("ssh kexinit malformed")
+
+// SSHOptions 识别到 ssh 服务后完成 KEXINIT 交换的参数
+type SSHOptions struct {
+	// 对服务端支持的每种主机密钥算法完成一次密钥交换 获取主机密钥指纹
+	HostKeys bool
+}
+
+// SSHInfo ssh 服务端 KEXINIT 中的算法以及 HASSH
+type SSHInfo struct {
+	Banner            string   `json:"banner"`
+	KexAlgorithms     []string `json:"kex_algorithms"`
+	HostKeyAlgorithms []string `json:"host_key_algorithms"`
+	// 服务端到客户端方向的加密 MAC 和压缩算法
+	Ciphers     []string `json:"ciphers"`
+	MACs        []string `json:"macs"`
+	Compression []string `json:"compression"`
+	// 客户端到服务端方向与服务端到客户端方向不同时记录
+	CiphersClientToServer     []string `json:"ciphers_client_to_server,omitempty"`
+	MACsClientToServer        []string `json:"macs_client_to_server,omitempty"`
+	CompressionClientToServer []string `json:"compression_client_to_server,omitempty"`
+	// HASSH-server 以及计算前的字符串
+	HASSHServer       string `json:"hassh_server"`
+	HASSHServerString string `json:"hassh_server_string"`
+	// 需要开启 SSHOptions.HostKeys
+	HostKeys []SSHHostKey `json:"host_keys,omitempty"`
+}
+
+// SSHHostKey 服务端的一个主机密钥
+type SSHHostKey struct {
+	Algorithm string `json:"algorithm"`
+	Type      string `json:"type"`
+	// 与 ssh-keygen -l 相同的 SHA256 指纹以及旧的 MD5 指纹
+	SHA256 string `json:"sha256"`
+	MD5    string `json:"md5"`
+}
+
+// sshKexInit SSH_MSG_KEXINIT 中的 10 个算法列表 顺序与报文一致
+type sshKexInit struct {
+	kex, hostKey                   []string
+	cipherC2S, cipherS2C           []string
+	macC2S, macS2C                 []string
+	compressionC2S, compressionS2C []string
+	languageC2S, languageS2C       []string
+}
+
+// enrichSSH 对识别为 ssh 的端口完成版本交换和 KEXINIT 交换 不进行认证
+func (n *Nmap) enrichSSH(ctx context.Context, response *Response, ip string, port int, cfg *scanConfig) {
+	dialer, err := NewDialer(n.option.Proxy, cfg.timeouts.Connect)
+	if err != nil {
+		cfg.logger.Debugf("Failed to create dialer: %s", err)
+		return
+	}
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
+	info, err := sshKexInfo(ctx, dialer, address, cfg.timeouts)
+	if err != nil {
+		cfg.logger.Debugf("SSH key exchange with %s failed: %s", address, err)
+		return
+	}
+	if cfg.ssh.HostKeys {
+		for _, algorithm := range info.HostKeyAlgorithms {
+			if ctx.Err() != nil {
+				break
+			}
+			key, err := sshHostKey(ctx, dialer, address, algorithm, cfg.timeouts)
+			if err != nil {
+				cfg.logger.Debugf("SSH host key %s of %s: %s", algorithm, address, err)
+				continue
+			}
+			info.HostKeys = append(info.HostKeys, *key)
+		}
+	}
+	response.SSH = info
+}
+
+// sshConn 建立连接并设置期限 期限为读取超时和 ctx 期限中较早的一个
+func sshConn(ctx context.Context, dialer proxy.Dialer, address string, timeouts Timeouts) (net.Conn, error) {
+	dialCtx, cancel := context.WithTimeout(ctx, timeouts.Connect)
+	defer cancel()
+	conn, err := dialContext(dialCtx, dialer, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(timeouts.Read)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = conn.SetDeadline(deadline)
+	return conn, nil
+}
+
+// sshKexInfo 交换版本后发送客户端 KEXINIT 并解析服务端 KEXINIT
+func sshKexInfo(ctx context.Context, dialer proxy.Dialer, address string, timeouts Timeouts) (*SSHInfo, error) {
+	conn, err := sshConn(ctx, dialer, address, timeouts)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(sshClientVersion + "\r\n")); err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	banner, err := readSSHBanner(reader)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := readSSHPacket(reader)
+	if err != nil {
+		return nil, err
+	}
+	kex, err := parseKexInit(payload)
+	if err != nil {
+		return nil, err
+	}
+	// 发送客户端 KEXINIT 完成交换 之后直接断开
+	_, _ = conn.Write(sshPacket(clientKexInit()))
+	info := &SSHInfo{
+		Banner:            banner,
+		KexAlgorithms:     kex.kex,
+		HostKeyAlgorithms: kex.hostKey,
+		Ciphers:           kex.cipherS2C,
+		MACs:              kex.macS2C,
+		Compression:       kex.compressionS2C,
+	}
+	if strings.Join(kex.cipherC2S, ",") != strings.Join(kex.cipherS2C, ",") {
+		info.CiphersClientToServer = kex.cipherC2S
+	}
+	if strings.Join(kex.macC2S, ",") != strings.Join(kex.macS2C, ",") {
+		info.MACsClientToServer = kex.macC2S
+	}
+	if strings.Join(kex.compressionC2S, ",") != strings.Join(kex.compressionS2C, ",") {
+		info.CompressionClientToServer = kex.compressionC2S
+	}
+	info.HASSHServerString, info.HASSHServer = kex.hasshServer()
+	return info, nil
+}
+
+// readSSHBanner 读取服务端版本行 跳过版本行之前的其他行
+func readSSHBanner(reader *bufio.Reader) (string, error) {
+	for i := 0; i < 32; i++ {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if strings.HasPrefix(line, "SSH-") {
+			return line, nil
+		}
+	}
+	return "", errors.New("ssh version line not found")
+}
+
+// readSSHPacket 读取一个未加密的二进制报文 返回 payload
+func readSSHPacket(reader io.Reader) ([]byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header)
+	padding := uint32(header[4])
+	if length > maxSSHPacket || length < padding+1 {
+		return nil, fmt.Errorf("invalid ssh packet length %d", length)
+	}
+	body := make([]byte, length-1)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return nil, err
+	}
+	return body[:length-1-padding], nil
+}
+
+// sshPacket 构造未加密的二进制报文 填充到 8 字节对齐 至少 4 字节
+func sshPacket(payload []byte) []byte {
+	padding := 8 - (len(payload)+5)%8
+	if padding < 4 {
+		padding += 8
+	}
+	packet := binary.BigEndian.AppendUint32(nil, uint32(len(payload)+padding+1))
+	packet = append(packet, byte(padding))
+	packet = append(packet, payload...)
+	return append(packet, randomBytes(padding)...)
+}
+
+// clientKexInit 客户端 KEXINIT 包含常见的算法
+func clientKexInit() []byte {
+	payload := []byte{sshMsgKexInit}
+	payload = append(payload, randomBytes(16)...)
+	lists := []string{
+		"curve25519-sha256,curve25519-sha256@libssh.org,ecdh-sha2-nistp256,diffie-hellman-group14-sha256,diffie-hellman-group14-sha1",
+		"ssh-ed25519,ecdsa-sha2-nistp256,rsa-sha2-512,rsa-sha2-256,ssh-rsa",
+		"aes128-ctr,aes256-ctr,aes128-gcm@openssh.com,chacha20-poly1305@openssh.com",
+		"aes128-ctr,aes256-ctr,aes128-gcm@openssh.com,chacha20-poly1305@openssh.com",
+		"hmac-sha2-256,hmac-sha2-512,hmac-sha1",
+		"hmac-sha2-256,hmac-sha2-512,hmac-sha1",
+		"none", "none", "", "",
+	}
+	for _, list := range lists {
+		payload = binary.BigEndian.AppendUint32(payload, uint32(len(list)))
+		payload = append(payload, list...)
+	}
+	// first_kex_packet_follows 以及保留字段
+	return append(payload, 0, 0, 0, 0, 0)
+}
+
+// parseKexInit 解析 SSH_MSG_KEXINIT payload
+func parseKexInit(payload []byte) (*sshKexInit, error) {
+	if len(payload) < 17 || payload[0] != sshMsgKexInit {
+		return nil, errSSHKexInit
+	}
+	data := payload[17:]
+	lists := make([][]string, 10)
+	for i := range lists {
+		if len(data) < 4 {
+			return nil, errSSHKexInit
+		}
+		size := binary.BigEndian.Uint32(data)
+		if uint64(size) > uint64(len(data)-4) {
+			return nil, errSSHKexInit
+		}
+		if size > 0 {
+			lists[i] = strings.Split(string(data[4:4+size]), ",")
+		}
+		data = data[4+size:]
+	}
+	return &sshKexInit{
+		kex: lists[0], hostKey: lists[1],
+		cipherC2S: lists[2], cipherS2C: lists[3],
+		macC2S: lists[4], macS2C: lists[5],
+		compressionC2S: lists[6], compressionS2C: lists[7],
+		languageC2S: lists[8], languageS2C: lists[9],
+	}, nil
+}
+
+// hasshServer 返回 HASSH-server 字符串 kex;cipher;mac;compression(服务端到客户端)以及它的 md5
+func (k *sshKexInit) hasshServer() (string, string) {
+	raw := strings.Join([]string{
+		strings.Join(k.kex, ","),
+		strings.Join(k.cipherS2C, ","),
+		strings.Join(k.macS2C, ","),
+		strings.Join(k.compressionS2C, ","),
+	}, ";")
+	sum := md5.Sum([]byte(raw))
+	return raw, hex.EncodeToString(sum[:])
+}
+
+var errHostKeyCollected = errors.New("host key collected")
+
+// sshHostKey 只允许一种主机密钥算法完成密钥交换 在验证主机密钥时中断连接
+func sshHostKey(ctx context.Context, dialer proxy.Dialer, address, algorithm string, timeouts Timeouts) (*SSHHostKey, error) {
+	conn, err := sshConn(ctx, dialer, address, timeouts)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var hostKey ssh.PublicKey
+	config := &ssh.ClientConfig{
+		User:              "gonmap",
+		ClientVersion:     sshClientVersion,
+		HostKeyAlgorithms: []string{algorithm},
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			hostKey = key
+			return errHostKeyCollected
+		},
+	}
+	_, _, _, err = ssh.NewClientConn(conn, address, config)
+	if hostKey == nil {
+		if err == nil {
+			err = errors.New("no host key")
+		}
+		return nil, err
+	}
+	return &SSHHostKey{
+		Algorithm: algorithm,
+		Type:      hostKey.Type(),
+		SHA256:    ssh.FingerprintSHA256(hostKey),
+		MD5:       ssh.FingerprintLegacyMD5(hostKey),
+	}, nil
+}
@@ -0,0 +1,80 @@
+package gonmap
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestEnrichSSH(t *testing.T) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		ServerVersion: "SSH-2.0-OpenSSH_9.6p1 Ubuntu-3ubuntu13",
+		NoClientAuth:  true,
+		Config: ssh.Config{
+			KeyExchanges: []string{"curve25519-sha256", "diffie-hellman-group14-sha256"},
+			Ciphers:      []string{"aes128-ctr", "aes256-ctr"},
+			MACs:         []string{"hmac-sha2-256"},
+		},
+	}
+	config.AddHostKey(signer)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+				_, _, _, _ = ssh.NewServerConn(conn, config)
+			}()
+		}
+	}()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	n := New(&Options{VersionIntensity: 7, Timeout: 1})
+	options := n.DefaultScanOptions()
+	options.Probes = []string{"NULL"}
+	options.Timeouts.Read = time.Second
+	options.SSH = &SSHOptions{HostKeys: true}
+	response := n.ScanWithOptions(context.Background(), TCP, "127.0.0.1", port, options)
+	if assert.Equal(t, StatusMatched, response.Status) && assert.NotNil(t, response.SSH) {
+		info := response.SSH
+		assert.Equal(t, "SSH-2.0-OpenSSH_9.6p1 Ubuntu-3ubuntu13", info.Banner)
+		assert.Equal(t, []string{"aes128-ctr", "aes256-ctr"}, info.Ciphers)
+		assert.Equal(t, []string{"hmac-sha2-256"}, info.MACs)
+		assert.Equal(t, []string{"none"}, info.Compression)
+		assert.Contains(t, info.KexAlgorithms, "curve25519-sha256")
+		assert.Equal(t, []string{"ssh-ed25519"}, info.HostKeyAlgorithms)
+		expected := strings.Join(info.KexAlgorithms, ",") + ";aes128-ctr,aes256-ctr;hmac-sha2-256;none"
+		sum := md5.Sum([]byte(expected))
+		assert.Equal(t, expected, info.HASSHServerString)
+		assert.Equal(t, hex.EncodeToString(sum[:]), info.HASSHServer)
+		if assert.Len(t, info.HostKeys, 1) {
+			assert.Equal(t, ssh.FingerprintSHA256(signer.PublicKey()), info.HostKeys[0].SHA256)
+			assert.Equal(t, "ssh-ed25519", info.HostKeys[0].Type)
+		}
+	}
+}
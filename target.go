@@ -0,0 +1,282 @@
+package gonmap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// TargetOptions 目标展开选项
+type TargetOptions struct {
+	// 目标未携带端口时使用的端口列表
+	Ports []int
+	// 排除的主机 支持 IP CIDR IP 段以及主机名
+	ExcludeHosts []string
+	// 排除的端口
+	ExcludePorts []int
+	// 将主机名解析为全部 A/AAAA 记录 否则保持主机名交给拨号时解析
+	ResolveAll bool
+	// 自定义解析器 为空时使用 net.DefaultResolver
+	Resolver *net.Resolver
+}
+
+// TargetExpander 将 CIDR IP 段 主机名等输入惰性展开为 host:port
+type TargetExpander struct {
+	ports        []int
+	excludeAddrs []addrRange
+	excludeNames map[string]struct{}
+	excludePorts map[int]struct{}
+	resolveAll   bool
+	resolver     *net.Resolver
+}
+
+// addrRange 闭区间地址段 CIDR 和单个 IP 也统一转换为地址段
+type addrRange struct {
+	start netip.Addr
+	end   netip.Addr
+}
+
+func (r addrRange) contains(addr netip.Addr) bool {
+	return r.start.BitLen() == addr.BitLen() && r.start.Compare(addr) <= 0 && addr.Compare(r.end) <= 0
+}
+
+func NewTargetExpander(options *TargetOptions) (*TargetExpander, error) {
+	e := &TargetExpander{
+		ports:        options.Ports,
+		excludeNames: make(map[string]struct{}),
+		excludePorts: make(map[int]struct{}),
+		resolveAll:   options.ResolveAll,
+		resolver:     options.Resolver,
+	}
+	if e.resolver == nil {
+		e.resolver = net.DefaultResolver
+	}
+	for _, port := range options.ExcludePorts {
+		e.excludePorts[port] = struct{}{}
+	}
+	for _, item := range options.ExcludeHosts {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if r, ok, err := parseAddrRange(item); err != nil {
+			return nil, err
+		} else if ok {
+			e.excludeAddrs = append(e.excludeAddrs, r)
+			continue
+		}
+		e.excludeNames[strings.ToLower(item)] = struct{}{}
+	}
+	return e, nil
+}
+
+// Expand 展开单条输入 每得到一个 host:port 就回调一次 不在内存中保存整个列表
+// fn 返回错误或 ctx 结束时停止展开
+func (e *TargetExpander) Expand(ctx context.Context, input string, fn func(host string, port int) error) error {
+	host, ports, err := e.splitInput(input)
+	if err != nil {
+		return wrapError(ErrInvalidTarget, err)
+	}
+	if len(ports) == 0 {
+		return fmt.Errorf("%w: no port specified for %s", ErrInvalidTarget, input)
+	}
+	emit := func(addr string) error {
+		for _, port := range ports {
+			if _, ok := e.excludePorts[port]; ok {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := fn(addr, port); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	r, ok, err := parseAddrRange(host)
+	if err != nil {
+		return wrapError(ErrInvalidTarget, err)
+	}
+	if ok {
+		for addr := r.start; addr.IsValid() && addr.Compare(r.end) <= 0; addr = addr.Next() {
+			if e.isExcluded(addr) {
+				continue
+			}
+			if err := emit(addr.String()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if _, excluded := e.excludeNames[strings.ToLower(host)]; excluded {
+		return nil
+	}
+	if !e.resolveAll {
+		return emit(host)
+	}
+	addrs, err := e.resolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return wrapError(ErrInvalidTarget, err)
+	}
+	seen := make(map[netip.Addr]struct{}, len(addrs))
+	for _, addr := range addrs {
+		addr = addr.Unmap()
+		if _, ok := seen[addr]; ok || e.isExcluded(addr) {
+			continue
+		}
+		seen[addr] = struct{}{}
+		if err := emit(addr.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *TargetExpander) isExcluded(addr netip.Addr) bool {
+	for _, r := range e.excludeAddrs {
+		if r.contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitInput 拆分输入中的主机和端口 输入未携带端口时使用默认端口列表
+func (e *TargetExpander) splitInput(input string) (string, []int, error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return "", nil, ErrEmptyTarget
+	}
+	if strings.Contains(input, "://") {
+		u, err := url.Parse(input)
+		if err != nil {
+			return "", nil, err
+		}
+		if u.Port() != "" {
+			port, err := strconv.Atoi(u.Port())
+			if err != nil {
+				return "", nil, err
+			}
+			return u.Hostname(), []int{port}, nil
+		}
+		switch u.Scheme {
+		case "http":
+			return u.Hostname(), []int{80}, nil
+		case "https":
+			return u.Hostname(), []int{443}, nil
+		}
+		return u.Hostname(), e.ports, nil
+	}
+	// 单个冒号 或者 [ipv6]:port 视为携带端口
+	if strings.HasPrefix(input, "[") || strings.Count(input, ":") == 1 {
+		host, portStr, err := net.SplitHostPort(input)
+		if err != nil {
+			return "", nil, err
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil || port < 1 || port > 65535 {
+			return "", nil, fmt.Errorf("invalid port in %s", input)
+		}
+		return host, []int{port}, nil
+	}
+	return input, e.ports, nil
+}
+
+// parseAddrRange 解析 IP CIDR 以及 IP 段 非地址输入返回 ok=false
+// IP 段支持 10.0.0.1-10.0.0.50 以及 10.0.0.1-50 两种写法
+func parseAddrRange(s string) (addrRange, bool, error) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		addr = addr.Unmap()
+		return addrRange{start: addr, end: addr}, true, nil
+	}
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return addrRange{}, false, err
+		}
+		prefix = prefix.Masked()
+		return addrRange{start: prefix.Addr(), end: lastAddr(prefix)}, true, nil
+	}
+	i := strings.LastIndex(s, "-")
+	if i <= 0 {
+		return addrRange{}, false, nil
+	}
+	start, err := netip.ParseAddr(s[:i])
+	if err != nil {
+		return addrRange{}, false, nil
+	}
+	endStr := s[i+1:]
+	end, err := netip.ParseAddr(endStr)
+	if err != nil && start.Is4() {
+		// 10.0.0.1-50 只替换最后一段
+		last, convErr := strconv.Atoi(endStr)
+		if convErr != nil || last < 0 || last > 255 {
+			return addrRange{}, false, fmt.Errorf("invalid ip range %s", s)
+		}
+		b := start.As4()
+		b[3] = byte(last)
+		end, err = netip.AddrFrom4(b), nil
+	}
+	if err != nil || start.BitLen() != end.BitLen() || end.Less(start) {
+		return addrRange{}, false, fmt.Errorf("invalid ip range %s", s)
+	}
+	return addrRange{start: start, end: end}, true, nil
+}
+
+// lastAddr 返回 CIDR 中的最后一个地址
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	b := prefix.Addr().AsSlice()
+	for bit := prefix.Bits(); bit < len(b)*8; bit++ {
+		b[bit/8] |= 1 << (7 - bit%8)
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+// ParsePorts 解析端口表达式 例如 22,80,8000-8100 "-" 表示全部端口
+func ParsePorts(expr string) ([]int, error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "-" {
+		expr = "1-65535"
+	}
+	var ports []int
+	seen := map[int]struct{}{}
+	for _, item := range strings.Split(expr, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		start, end := item, item
+		if i := strings.Index(item, "-"); i >= 0 {
+			start, end = item[:i], item[i+1:]
+		}
+		startPort, err := strconv.Atoi(start)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %s", item)
+		}
+		endPort, err := strconv.Atoi(end)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %s", item)
+		}
+		if startPort < 1 || endPort > 65535 || startPort > endPort {
+			return nil, fmt.Errorf("invalid port range %s", item)
+		}
+		for port := startPort; port <= endPort; port++ {
+			if _, ok := seen[port]; ok {
+				continue
+			}
+			seen[port] = struct{}{}
+			ports = append(ports, port)
+		}
+	}
+	if len(ports) == 0 {
+		return nil, errors.New("empty port expression")
+	}
+	return ports, nil
+}
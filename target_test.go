@@ -0,0 +1,94 @@
+package gonmap
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func expandAll(t *testing.T, e *TargetExpander, input string) []string {
+	var result []string
+	err := e.Expand(context.Background(), input, func(host string, port int) error {
+		result = append(result, fmt.Sprintf("%s:%d", host, port))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestParsePorts(t *testing.T) {
+	ports, err := ParsePorts("22,80,8000-8002,80")
+	assert.NoError(t, err)
+	assert.Equal(t, []int{22, 80, 8000, 8001, 8002}, ports)
+	ports, err = ParsePorts("-")
+	assert.NoError(t, err)
+	assert.Equal(t, 65535, len(ports))
+	_, err = ParsePorts("0-10")
+	assert.Error(t, err)
+	_, err = ParsePorts("a")
+	assert.Error(t, err)
+}
+
+func TestTargetExpand(t *testing.T) {
+	e, err := NewTargetExpander(&TargetOptions{
+		Ports:        []int{22, 80},
+		ExcludeHosts: []string{"10.0.0.2", "10.0.0.4/31", "skip.example.com"},
+		ExcludePorts: []int{22},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1:80", "10.0.0.3:80", "10.0.0.6:80"}, expandAll(t, e, "10.0.0.1-6"))
+	assert.Equal(t, []string{"10.0.0.0:80", "10.0.0.1:80", "10.0.0.3:80"}, expandAll(t, e, "10.0.0.0/30"))
+	assert.Equal(t, []string{"10.0.0.9:8080"}, expandAll(t, e, "10.0.0.9:8080"))
+	assert.Equal(t, []string{"example.com:443"}, expandAll(t, e, "https://example.com/"))
+	assert.Equal(t, []string{"::1:80"}, expandAll(t, e, "::1"))
+	assert.Empty(t, expandAll(t, e, "skip.example.com"))
+	assert.Equal(t, 256, len(expandAll(t, e, "192.168.0.0/24")))
+	err = e.Expand(context.Background(), " ", func(string, int) error { return nil })
+	assert.ErrorIs(t, err, ErrEmptyTarget)
+	assert.ErrorIs(t, err, ErrInvalidTarget)
+}
+
+func TestTopPorts(t *testing.T) {
+	ports := TopPorts(3, TCP)
+	assert.Equal(t, []int{80, 23, 443}, ports)
+	assert.Equal(t, 631, TopPorts(1, UDP)[0])
+	// 与 nmap 的 top 100 和 top 1000 相同
+	assert.Equal(t, 37, TopPorts(100, TCP)[99])
+	assert.Len(t, TopPorts(1000, TCP), 1000)
+	assert.Len(t, TopPorts(5000, TCP), 1000)
+}
+
+func TestLookupService(t *testing.T) {
+	name, ok := LookupService(22, TCP)
+	assert.True(t, ok)
+	assert.Equal(t, "ssh", name)
+	name, _ = LookupService(53, UDP)
+	assert.Equal(t, "dns", name)
+	_, ok = LookupService(1, UDP)
+	assert.False(t, ok)
+	// 表中包含 nmap 默认扫描的 1000 个 tcp 端口
+	for _, port := range TopPorts(1000, TCP) {
+		found := false
+		for _, entry := range getServiceTable() {
+			if entry.Port == port && entry.Protocol == TCP {
+				found = true
+				break
+			}
+		}
+		assert.True(t, found, port)
+	}
+	name, _ = LookupService(5666, TCP)
+	assert.Equal(t, "nrpe", name)
+	_, ok = LookupService(49152, TCP)
+	assert.False(t, ok)
+
+	response := &Response{Status: StatusUnknown, Protocol: TCP}
+	guessService(response, 3306)
+	assert.Equal(t, MethodTable, response.Method)
+	assert.Equal(t, "mysql", response.Service.Service)
+	assert.Less(t, response.Confidence, confidenceProbed)
+}
@@ -0,0 +1,194 @@
+package gonmap
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+const (
+	// 读取 ServerHello 时最多读取的字节数
+	maxServerHelloSize = 16 * 1024
+	// 计算 JA3S 使用的 JARM 探测
+	jarmJA3SProbe = 6
+)
+
+var errNoServerHello = errors.New("no server hello")
+
+// TLSFingerprint TLS 服务端指纹
+type TLSFingerprint struct {
+	// 10 个 ClientHello 的响应计算得到的 JARM 服务端不响应时为 62 个 0
+	JARM string `json:"jarm,omitempty"`
+	// 标准 ClientHello 的 ServerHello 计算得到的 JA3S 以及计算前的字符串
+	JA3S       string `json:"ja3s,omitempty"`
+	JA3SString string `json:"ja3s_string,omitempty"`
+}
+
+// serverHello ServerHello 中计算指纹需要的字段
+type serverHello struct {
+	version       uint16
+	cipher        uint16
+	hasExtensions bool
+	extensions    []uint16
+	// 协商的第一个 ALPN 协议
+	alpn string
+}
+
+// readServerHello 读取 TLS 记录直到得到完整的 ServerHello 消息 收到告警或其他记录时返回已读取的数据
+func readServerHello(conn net.Conn) []byte {
+	var data []byte
+	var handshake []byte
+	header := make([]byte, 5)
+	for len(data) < maxServerHelloSize {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return data
+		}
+		data = append(data, header...)
+		length := int(binary.BigEndian.Uint16(header[3:5]))
+		payload := make([]byte, length)
+		n, err := io.ReadFull(conn, payload)
+		data = append(data, payload[:n]...)
+		if err != nil || header[0] != 0x16 {
+			return data
+		}
+		handshake = append(handshake, payload...)
+		if len(handshake) >= 4 && len(handshake) >= 4+(int(handshake[1])<<16|int(handshake[2])<<8|int(handshake[3])) {
+			return data
+		}
+	}
+	return data
+}
+
+// parseServerHello 从 TLS 记录中解析 ServerHello
+func parseServerHello(data []byte) (*serverHello, error) {
+	var handshake []byte
+	for len(data) >= 5 && data[0] == 0x16 {
+		length := int(binary.BigEndian.Uint16(data[3:5]))
+		if len(data) < 5+length {
+			handshake = append(handshake, data[5:]...)
+			break
+		}
+		handshake = append(handshake, data[5:5+length]...)
+		data = data[5+length:]
+	}
+	if len(handshake) < 4 || handshake[0] != 2 {
+		return nil, errNoServerHello
+	}
+	length := int(handshake[1])<<16 | int(handshake[2])<<8 | int(handshake[3])
+	body := handshake[4:]
+	if len(body) > length {
+		body = body[:length]
+	}
+	// version(2) random(32) session_id_length(1)
+	if len(body) < 35 {
+		return nil, errNoServerHello
+	}
+	hello := &serverHello{version: binary.BigEndian.Uint16(body)}
+	sessionID := int(body[34])
+	body = body[35:]
+	// session_id cipher(2) compression(1)
+	if len(body) < sessionID+3 {
+		return nil, errNoServerHello
+	}
+	hello.cipher = binary.BigEndian.Uint16(body[sessionID:])
+	body = body[sessionID+3:]
+	if len(body) < 2 {
+		return hello, nil
+	}
+	hello.hasExtensions = true
+	extLength := int(binary.BigEndian.Uint16(body))
+	body = body[2:]
+	if len(body) > extLength {
+		body = body[:extLength]
+	}
+	for len(body) >= 4 {
+		extType := binary.BigEndian.Uint16(body)
+		size := int(binary.BigEndian.Uint16(body[2:]))
+		if len(body) < 4+size {
+			break
+		}
+		value := body[4 : 4+size]
+		hello.extensions = append(hello.extensions, extType)
+		// ALPN 列表长度(2) 协议长度(1) 协议
+		if extType == 0x0010 && len(value) > 3 {
+			hello.alpn = string(value[3:])
+		}
+		body = body[4+size:]
+	}
+	return hello, nil
+}
+
+// ja3s 返回 JA3S 字符串 SSLVersion,Cipher,SSLExtension 以及它的 md5
+func (h *serverHello) ja3s() (string, string) {
+	extensions := make([]string, 0, len(h.extensions))
+	for _, ext := range h.extensions {
+		extensions = append(extensions, strconv.Itoa(int(ext)))
+	}
+	raw := fmt.Sprintf("%d,%d,%s", h.version, h.cipher, strings.Join(extensions, "-"))
+	sum := md5.Sum([]byte(raw))
+	return raw, hex.EncodeToString(sum[:])
+}
+
+// tlsHandshake 发送 ClientHello 并返回服务端的响应 连接失败时返回错误
+func tlsHandshake(ctx context.Context, dialer proxy.Dialer, address string, hello []byte, timeouts Timeouts) ([]byte, error) {
+	dialCtx, cancel := context.WithTimeout(ctx, timeouts.Connect)
+	defer cancel()
+	conn, err := dialContext(dialCtx, dialer, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(timeouts.Read)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = conn.SetDeadline(deadline)
+	if _, err := conn.Write(hello); err != nil {
+		return nil, err
+	}
+	return readServerHello(conn), nil
+}
+
+// fingerprintTLS 计算 TLS 端口的 JARM 和 JA3S
+// JA3S 使用 JARM 中支持 TLS 1.3 的正序 ClientHello 的响应
+func (n *Nmap) fingerprintTLS(ctx context.Context, response *Response, ip string, port int, cfg *scanConfig) {
+	dialer, err := NewDialer(n.option.Proxy, cfg.timeouts.Connect)
+	if err != nil {
+		cfg.logger.Debugf("Failed to create dialer: %s", err)
+		return
+	}
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
+	serverName := cfg.serverName
+	if serverName == "" {
+		serverName = ip
+	}
+	fingerprint := &TLSFingerprint{}
+	results := make([]string, 0, len(jarmProbes))
+	for i, probe := range jarmProbes {
+		if ctx.Err() != nil {
+			return
+		}
+		data, err := tlsHandshake(ctx, dialer, address, probe.clientHello(serverName), cfg.timeouts)
+		if err != nil {
+			cfg.logger.Debugf("JARM probe %d to %s failed: %s", i, address, err)
+		}
+		results = append(results, jarmResult(data))
+		if i == jarmJA3SProbe {
+			if hello, err := parseServerHello(data); err == nil {
+				fingerprint.JA3SString, fingerprint.JA3S = hello.ja3s()
+			}
+		}
+	}
+	fingerprint.JARM = jarmHash(results)
+	response.TLSFingerprint = fingerprint
+}
@@ -0,0 +1,78 @@
+package gonmap
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJARMHash(t *testing.T) {
+	items := []int{1, 2, 3, 4, 5}
+	assert.Equal(t, []int{5, 4, 3, 2, 1}, mung(items, "REVERSE"))
+	assert.Equal(t, []int{4, 5}, mung(items, "BOTTOM_HALF"))
+	assert.Equal(t, []int{3, 2, 1}, mung(items, "TOP_HALF"))
+	assert.Equal(t, []int{3, 4, 2, 5, 1}, mung(items, "MIDDLE_OUT"))
+	assert.Equal(t, []int{3, 2, 4, 1}, mung([]int{1, 2, 3, 4}, "MIDDLE_OUT"))
+
+	results := make([]string, len(jarmProbes))
+	for i := range results {
+		results[i] = "|||"
+	}
+	assert.Equal(t, jarmEmpty, jarmHash(results))
+	results[0] = "c02f|0303|h2|ff01-0000-0001-000b-0023-0010"
+	sum := sha256.Sum256([]byte("h2ff01-0000-0001-000b-0023-0010"))
+	assert.Equal(t, "29d"+strings.Repeat("000", 9)+hex.EncodeToString(sum[:])[:32], jarmHash(results))
+
+	// 构造的 ClientHello 长度字段一致
+	for _, probe := range jarmProbes {
+		hello := probe.clientHello("example.com")
+		assert.Equal(t, byte(0x16), hello[0])
+		assert.Equal(t, len(hello)-5, int(hello[3])<<8|int(hello[4]))
+		assert.Equal(t, len(hello)-9, int(hello[6])<<16|int(hello[7])<<8|int(hello[8]))
+	}
+}
+
+func TestTLSFingerprint(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	// JARM 的探测大多握手失败 不输出服务端日志
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+	_, portValue, _ := net.SplitHostPort(server.Listener.Addr().String())
+	port, _ := strconv.Atoi(portValue)
+
+	n := New(&Options{VersionIntensity: 7, Timeout: 1})
+	options := n.DefaultScanOptions()
+	options.Probes = []string{"GetRequest"}
+	options.TLS = TLSOn
+	options.Timeouts.Read = time.Second
+	options.TLSFingerprint = true
+	response := n.ScanWithOptions(context.Background(), TCP, "127.0.0.1", port, options)
+	assert.True(t, response.Tls)
+	if assert.NotNil(t, response.TLSFingerprint) {
+		fingerprint := response.TLSFingerprint
+		assert.Len(t, fingerprint.JARM, 62)
+		assert.NotEqual(t, jarmEmpty, fingerprint.JARM)
+		// TLS 1.3 的 ServerHello 包含 supported_versions 和 key_share
+		assert.True(t, strings.HasPrefix(fingerprint.JA3SString, "771,"), fingerprint.JA3SString)
+		assert.Contains(t, fingerprint.JA3SString, "43")
+		assert.Contains(t, fingerprint.JA3SString, "51")
+		assert.Len(t, fingerprint.JA3S, 32)
+	}
+
+	options.TLSFingerprint = false
+	assert.Nil(t, n.ScanWithOptions(context.Background(), TCP, "127.0.0.1", port, options).TLSFingerprint)
+}
@@ -1,20 +1,56 @@
 package gonmap
 
 type MatchResult struct {
-	Service  string
-	Version  string
-	Product  string
-	Response []byte
-	match    *match
+	Service    string
+	Version    string
+	Product    string
+	Info       string
+	Hostname   string
+	OS         string
+	DeviceType string
+	CPE        []string
+	Response   []byte
+	// 得到响应的探针
+	Probe string
+	// softmatch 只确定了服务 没有版本信息
+	Soft bool
+	// 由 fallback 探针或其他探针的指纹匹配
+	Fallback bool
+	// 检测器得到的结构化信息 由 Response 中对应的字段输出
+	Details any `json:"-"`
+	match   *match
 }
 
 type Status string
 
 const (
+	StatusOpen       Status = "open"
 	StatusClose      Status = "close"
+	StatusFiltered   Status = "filtered"
 	StatusUnknown    Status = "unknown"
 	StatusMatched    Status = "matched"
 	StatusTcpWrapped Status = "tcpwrapped"
+	// 端口在探针文件的 Exclude 指令中 不进行识别
+	StatusExcluded Status = "excluded"
+	// 代理连接失败 无法判断端口状态
+	StatusProxyError Status = "proxy-error"
+	// 本地资源不足导致无法连接 需要重试
+	StatusResourceError Status = "resource-error"
+)
+
+// Method 服务名称的来源
+type Method string
+
+const (
+	// MethodProbed 由探针响应匹配得到
+	MethodProbed Method = "probed"
+	// MethodTable 没有探针匹配 按照端口服务表猜测
+	MethodTable Method = "table"
+)
+
+const (
+	confidenceProbed = 10
+	confidenceTable  = 3
 )
 
 type Response struct {
@@ -23,4 +59,34 @@ type Response struct {
 	Status   Status       `json:"status"`
 	Service  *MatchResult `json:"service"`
 	Protocol Protocol     `json:"protocol"`
+	// 端口状态的依据 例如 syn-ack conn-refused no-response
+	Reason string `json:"reason,omitempty"`
+	// 导致扫描没有结果的错误 可以通过 errors.Is 与 ErrProxy 等错误比较 Error 为错误信息
+	Err   error  `json:"-"`
+	Error string `json:"error,omitempty"`
+	// 超时预算耗尽导致扫描结束时 记录耗尽的预算
+	Budget Budget `json:"budget,omitempty"`
+	// 第一个有数据的响应 没有匹配时也会返回
+	Banner []byte `json:"banner,omitempty"`
+	// 达到探针数量上限 还有探针没有发送
+	Truncated bool `json:"truncated,omitempty"`
+	// 服务名称的来源以及可信度 0-10 与 nmap 的 method 和 conf 对应
+	Method     Method `json:"method,omitempty"`
+	Confidence int    `json:"confidence,omitempty"`
+	// 服务验证模式的结论
+	Verdict Verdict `json:"verdict,omitempty"`
+	// http/https 服务的页面信息 需要开启 ScanOptions.HTTP
+	HTTP *HTTPInfo `json:"http,omitempty"`
+	// 页面识别到的 Web 应用 需要设置 HTTPOptions.Fingerprinter
+	Apps []WebApp `json:"apps,omitempty"`
+	// TLS 端口的 JARM 和 JA3S 需要开启 ScanOptions.TLSFingerprint
+	TLSFingerprint *TLSFingerprint `json:"tls_fingerprint,omitempty"`
+	// ssh 服务的算法 HASSH 和主机密钥 需要设置 ScanOptions.SSH
+	SSH *SSHInfo `json:"ssh,omitempty"`
+	// RDPNegotiation 检测器得到的安全层和 NTLM 信息
+	RDP *RDPInfo `json:"rdp,omitempty"`
+	// SMB2Negotiate 检测器得到的方言 签名和 NTLM 信息
+	SMB *SMBInfo `json:"smb,omitempty"`
+	// 数据库等检测器得到的服务相关信息 键由检测器决定
+	Details map[string]any `json:"details,omitempty"`
 }
@@ -0,0 +1,73 @@
+package gonmap
+
+import (
+	"context"
+	"strings"
+)
+
+// Verdict 服务验证的结论
+type Verdict string
+
+const (
+	// VerdictConfirmed 识别到期望的服务
+	VerdictConfirmed Verdict = "confirmed"
+	// VerdictMismatched 识别到其他服务 Response.Service 为实际识别结果
+	VerdictMismatched Verdict = "mismatched"
+	// VerdictUnknown 相关探针都没有匹配 无法确认
+	VerdictUnknown Verdict = "unknown"
+)
+
+// Verify 验证端口是否运行期望的服务 services 为任意一个即可
+// 只发送指纹中包含这些服务的探针 比完整识别快得多 options 为空时使用默认参数
+func (n *Nmap) Verify(ctx context.Context, protocol Protocol, ip string, port int, services []string, options *ScanOptions) *Response {
+	cfg := n.scanConfig(options)
+	cfg.expect = expectServices(services)
+	response := n.scan(ctx, protocol, ip, port, cfg)
+	response.Verdict = verdict(response, cfg.expect)
+	return response
+}
+
+// expectServices 统一服务名称 https 与 xxx-ssl 同时期望明文服务 TLS 之上的指纹按明文服务记录
+func expectServices(services []string) map[string]struct{} {
+	expect := make(map[string]struct{}, len(services))
+	for _, service := range services {
+		service = FixProtocol(strings.ToLower(strings.TrimSpace(service)))
+		if service == "" {
+			continue
+		}
+		expect[service] = struct{}{}
+		expect[plainService(service)] = struct{}{}
+	}
+	return expect
+}
+
+// plainService 去掉服务名称中的 TLS 标记
+func plainService(service string) string {
+	if service == "https" {
+		return "http"
+	}
+	return strings.TrimSuffix(service, "-ssl")
+}
+
+// identifies 探针的指纹中是否包含任意一个服务
+func (p *probe) identifies(services map[string]struct{}) bool {
+	for service := range services {
+		if _, ok := p.services[service]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func verdict(response *Response, expect map[string]struct{}) Verdict {
+	if response.Status != StatusMatched || response.Service == nil {
+		return VerdictUnknown
+	}
+	if _, ok := expect[response.Service.Service]; ok {
+		return VerdictConfirmed
+	}
+	if _, ok := expect[plainService(response.Service.Service)]; ok {
+		return VerdictConfirmed
+	}
+	return VerdictMismatched
+}
@@ -0,0 +1,404 @@
+package gonmap
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 识别 Web 应用时最多请求的脚本文件数
+const maxWebScripts = 5
+
+// WebApp 识别到的 Web 应用
+type WebApp struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	// 规则中其他提取器的结果
+	Extract map[string]string `json:"extract,omitempty"`
+}
+
+// FetchFunc 请求与页面同一主机的地址 返回响应正文
+type FetchFunc func(ctx context.Context, location string) ([]byte, error)
+
+// WebFingerprinter 识别 Web 应用 在获取 http/https 页面信息之后调用
+// fetch 只能请求与页面同一主机的地址 可以用于获取脚本等额外资源
+type WebFingerprinter interface {
+	Fingerprint(ctx context.Context, page *HTTPInfo, fetch FetchFunc) []WebApp
+}
+
+// WebRules 从 YAML 规则目录加载的 Web 指纹 实现 WebFingerprinter
+type WebRules struct {
+	rules    []*webRule
+	warnings []string
+	// 不请求页面引用的脚本 part 为 js 的匹配器不会匹配
+	DisableJavaScript bool
+}
+
+type webRule struct {
+	Name              string          `yaml:"name"`
+	MatchersCondition string          `yaml:"matchers-condition"`
+	Matchers          []*webMatcher   `yaml:"matchers"`
+	Extractors        []*webExtractor `yaml:"extractors"`
+}
+
+type webMatcher struct {
+	// word regex favicon
+	Type string `yaml:"type"`
+	// body header title server js 默认为 body
+	Part            string   `yaml:"part"`
+	Words           []string `yaml:"words"`
+	Regex           []string `yaml:"regex"`
+	Hash            []string `yaml:"hash"`
+	Condition       string   `yaml:"condition"`
+	Negative        bool     `yaml:"negative"`
+	CaseInsensitive bool     `yaml:"case-insensitive"`
+	regexps         []*regexp.Regexp
+}
+
+type webExtractor struct {
+	// 为 version 时作为应用版本
+	Name  string   `yaml:"name"`
+	Part  string   `yaml:"part"`
+	Regex []string `yaml:"regex"`
+	Group int      `yaml:"group"`
+
+	regexps []*regexp.Regexp
+}
+
+// LoadWebRules 递归加载目录中的全部 .yaml/.yml 规则文件 每个文件包含一条规则或规则列表
+// 格式错误的文件和规则跳过 通过 Warnings 返回
+func LoadWebRules(dir string) (*WebRules, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	rules := &WebRules{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		ext := strings.ToLower(filepath.Ext(path))
+		if d.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		loaded, warnings := parseWebRules(data)
+		for _, warning := range warnings {
+			rules.warnings = append(rules.warnings, fmt.Sprintf("%s: %s", path, warning))
+		}
+		rules.rules = append(rules.rules, loaded...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// parseWebRules 解析一个规则文件 返回有效的规则和警告
+// 文件可以是规则列表 也可以是单条规则
+func parseWebRules(data []byte) ([]*webRule, []string) {
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return nil, []string{err.Error()}
+	}
+	if len(node.Content) == 0 {
+		return nil, nil
+	}
+	var rules []*webRule
+	var err error
+	if node.Content[0].Kind == yaml.MappingNode {
+		rule := &webRule{}
+		err = node.Content[0].Decode(rule)
+		rules = append(rules, rule)
+	} else {
+		err = node.Content[0].Decode(&rules)
+	}
+	if err != nil {
+		return nil, []string{err.Error()}
+	}
+	var valid []*webRule
+	var warnings []string
+	for i, rule := range rules {
+		if rule == nil {
+			continue
+		}
+		if err := rule.compile(); err != nil {
+			warnings = append(warnings, fmt.Sprintf("rule %d %s: %s", i, rule.Name, err))
+			continue
+		}
+		valid = append(valid, rule)
+	}
+	return valid, warnings
+}
+
+func (r *webRule) compile() error {
+	if r.Name == "" {
+		return fmt.Errorf("missing name")
+	}
+	if len(r.Matchers) == 0 {
+		return fmt.Errorf("missing matchers")
+	}
+	if err := checkCondition(r.MatchersCondition); err != nil {
+		return err
+	}
+	for _, m := range r.Matchers {
+		if err := checkCondition(m.Condition); err != nil {
+			return err
+		}
+		if err := checkPart(m.Part); err != nil {
+			return err
+		}
+		switch m.Type {
+		case "word":
+			if len(m.Words) == 0 {
+				return fmt.Errorf("word matcher without words")
+			}
+			if m.CaseInsensitive {
+				for i, word := range m.Words {
+					m.Words[i] = strings.ToLower(word)
+				}
+			}
+		case "regex":
+			for _, expr := range m.Regex {
+				if m.CaseInsensitive {
+					expr = "(?i)" + expr
+				}
+				re, err := regexp.Compile(expr)
+				if err != nil {
+					return err
+				}
+				m.regexps = append(m.regexps, re)
+			}
+			if len(m.regexps) == 0 {
+				return fmt.Errorf("regex matcher without regex")
+			}
+		case "favicon":
+			if len(m.Hash) == 0 {
+				return fmt.Errorf("favicon matcher without hash")
+			}
+		default:
+			return fmt.Errorf("unknown matcher type %q", m.Type)
+		}
+	}
+	for _, e := range r.Extractors {
+		if err := checkPart(e.Part); err != nil {
+			return err
+		}
+		for _, expr := range e.Regex {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return err
+			}
+			if e.Group > re.NumSubexp() {
+				return fmt.Errorf("extractor %s group %d out of range", e.Name, e.Group)
+			}
+			e.regexps = append(e.regexps, re)
+		}
+	}
+	return nil
+}
+
+func checkCondition(condition string) error {
+	if condition != "" && condition != "and" && condition != "or" {
+		return fmt.Errorf("unknown condition %q", condition)
+	}
+	return nil
+}
+
+func checkPart(part string) error {
+	switch part {
+	case "", "body", "header", "title", "server", "js":
+		return nil
+	}
+	return fmt.Errorf("unknown part %q", part)
+}
+
+// Len 加载的规则数量
+func (r *WebRules) Len() int {
+	return len(r.rules)
+}
+
+// Warnings 加载时跳过的文件和规则
+func (r *WebRules) Warnings() []string {
+	return append([]string(nil), r.warnings...)
+}
+
+// webPage 匹配时使用的页面内容 脚本内容在第一次使用时获取
+type webPage struct {
+	info    *HTTPInfo
+	header  string
+	scripts *string
+	fetch   func() string
+}
+
+func (p *webPage) part(name string) string {
+	switch name {
+	case "header":
+		return p.header
+	case "title":
+		return p.info.Title
+	case "server":
+		return p.info.Server
+	case "js":
+		if p.scripts == nil {
+			scripts := p.fetch()
+			p.scripts = &scripts
+		}
+		return *p.scripts
+	}
+	return string(p.info.Body)
+}
+
+func (r *WebRules) Fingerprint(ctx context.Context, page *HTTPInfo, fetch FetchFunc) []WebApp {
+	p := &webPage{info: page, header: formatHeader(page)}
+	p.fetch = func() string {
+		if r.DisableJavaScript || fetch == nil {
+			return ""
+		}
+		return fetchScripts(ctx, page, fetch)
+	}
+	var apps []WebApp
+	seen := make(map[string]bool)
+	for _, rule := range r.rules {
+		if seen[rule.Name] || !rule.match(p) {
+			continue
+		}
+		seen[rule.Name] = true
+		apps = append(apps, rule.extract(p))
+	}
+	return apps
+}
+
+func (r *webRule) match(p *webPage) bool {
+	and := r.MatchersCondition == "and"
+	for _, m := range r.Matchers {
+		matched := m.match(p)
+		if and && !matched {
+			return false
+		}
+		if !and && matched {
+			return true
+		}
+	}
+	return and
+}
+
+func (m *webMatcher) match(p *webPage) bool {
+	and := m.Condition == "and"
+	var results []bool
+	switch m.Type {
+	case "favicon":
+		for _, hash := range m.Hash {
+			results = append(results, p.info.FaviconMD5 != "" &&
+				(strings.EqualFold(hash, p.info.FaviconMD5) || hash == strconv.Itoa(int(p.info.FaviconMMH3))))
+		}
+	case "word":
+		text := p.part(m.Part)
+		if m.CaseInsensitive {
+			text = strings.ToLower(text)
+		}
+		for _, word := range m.Words {
+			results = append(results, strings.Contains(text, word))
+		}
+	case "regex":
+		text := p.part(m.Part)
+		for _, re := range m.regexps {
+			results = append(results, re.MatchString(text))
+		}
+	}
+	matched := and
+	for _, result := range results {
+		if and && !result {
+			matched = false
+			break
+		}
+		if !and && result {
+			matched = true
+			break
+		}
+	}
+	return matched != m.Negative
+}
+
+// extract 运行提取器 名称为 version 的结果作为应用版本
+func (r *webRule) extract(p *webPage) WebApp {
+	app := WebApp{Name: r.Name}
+	for _, e := range r.Extractors {
+		text := p.part(e.Part)
+		for _, re := range e.regexps {
+			m := re.FindStringSubmatch(text)
+			if m == nil {
+				continue
+			}
+			value := strings.TrimSpace(m[e.Group])
+			if e.Name == "version" {
+				app.Version = value
+			} else if e.Name != "" {
+				if app.Extract == nil {
+					app.Extract = make(map[string]string)
+				}
+				app.Extract[e.Name] = value
+			}
+			break
+		}
+	}
+	return app
+}
+
+// formatHeader 包含状态行的响应头文本 按名称排序
+func formatHeader(info *HTTPInfo) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "HTTP %d\r\n", info.StatusCode)
+	keys := make([]string, 0, len(info.Header))
+	for key := range info.Header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range info.Header[key] {
+			fmt.Fprintf(&b, "%s: %s\r\n", key, value)
+		}
+	}
+	return b.String()
+}
+
+var scriptRegx = regexp.MustCompile(`(?is)<script[^>]*\ssrc\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+
+// fetchScripts 获取页面引用的同一主机的脚本 最多 maxWebScripts 个
+func fetchScripts(ctx context.Context, page *HTTPInfo, fetch FetchFunc) string {
+	base, err := url.Parse(page.URL)
+	if err != nil {
+		return ""
+	}
+	var b strings.Builder
+	count := 0
+	for _, m := range scriptRegx.FindAllSubmatch(page.Body, -1) {
+		if count >= maxWebScripts || ctx.Err() != nil {
+			break
+		}
+		src, err := base.Parse(string(m[1]) + string(m[2]) + string(m[3]))
+		if err != nil || src.Hostname() != base.Hostname() {
+			continue
+		}
+		count++
+		data, err := fetch(ctx, src.String())
+		if err != nil {
+			continue
+		}
+		b.Write(data)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
@@ -0,0 +1,153 @@
+package gonmap
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const webRules = `
+- name: nginx
+  matchers:
+    - type: word
+      part: server
+      words: ["nginx"]
+      case-insensitive: true
+  extractors:
+    - type: regex
+      name: version
+      part: server
+      regex: ['nginx/([\d.]+)']
+      group: 1
+- name: grafana
+  matchers-condition: and
+  matchers:
+    - type: regex
+      part: title
+      regex: ["^Grafana"]
+    - type: word
+      words: ["grafana-app", "window.grafanaBootData"]
+      condition: or
+  extractors:
+    - name: version
+      regex: ['"version":"([\d.]+)"']
+      group: 1
+- name: custom-icon
+  matchers:
+    - type: favicon
+      hash: ["{{mmh3}}"]
+- name: vue
+  matchers:
+    - type: word
+      part: js
+      words: ["Vue.version"]
+- name: not-apache
+  matchers:
+    - type: word
+      part: header
+      words: ["Apache"]
+      negative: true
+`
+
+func TestWebRules(t *testing.T) {
+	icon := []byte("icon data")
+	dir := t.TempDir()
+	rules := []byte(strings.ReplaceAll(webRules, "{{mmh3}}", strconv.Itoa(int(faviconHash(icon)))))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "apps.yaml"), rules, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "bad.yml"), []byte("- name: broken\n  matchers:\n    - type: regex\n      regex: ['(']\n"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte("not a rule"), 0644))
+
+	loaded, err := LoadWebRules(dir)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 5, loaded.Len())
+	assert.Len(t, loaded.Warnings(), 1)
+
+	page := &HTTPInfo{
+		URL:        "http://127.0.0.1:3000/login",
+		StatusCode: 200,
+		Title:      "Grafana",
+		Server:     "nginx/1.25.3",
+		Header:     http.Header{"Server": {"nginx/1.25.3"}},
+		Body:       []byte(`<div class="grafana-app"></div><script>{"version":"10.2.0"}</script><script src="/public/app.js"></script>`),
+	}
+	page.FaviconMD5 = "md5"
+	page.FaviconMMH3 = faviconHash(icon)
+	var fetched []string
+	fetch := func(ctx context.Context, location string) ([]byte, error) {
+		fetched = append(fetched, location)
+		if location == "http://127.0.0.1:3000/public/app.js" {
+			return []byte("Vue.version = '3.3.4'"), nil
+		}
+		return nil, errors.New("not found")
+	}
+	apps := loaded.Fingerprint(context.Background(), page, fetch)
+	assert.Equal(t, []WebApp{
+		{Name: "nginx", Version: "1.25.3"},
+		{Name: "grafana", Version: "10.2.0"},
+		{Name: "custom-icon"},
+		{Name: "vue"},
+		{Name: "not-apache"},
+	}, apps)
+	assert.Equal(t, []string{"http://127.0.0.1:3000/public/app.js"}, fetched)
+
+	// 关闭脚本请求后 js 规则不匹配
+	loaded.DisableJavaScript = true
+	fetched = nil
+	apps = loaded.Fingerprint(context.Background(), page, fetch)
+	assert.Len(t, apps, 4)
+	assert.Empty(t, fetched)
+
+	_, err = LoadWebRules(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
+
+func TestWebRulesFixture(t *testing.T) {
+	// testdata/finger 与 -update-rule 解压后的目录结构一致 按分类存放 单条规则和规则列表混用
+	loaded, err := LoadWebRules(filepath.Join("testdata", "finger"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 5, loaded.Len())
+	assert.Empty(t, loaded.Warnings())
+
+	page := &HTTPInfo{
+		URL:        "http://127.0.0.1/",
+		StatusCode: 200,
+		Title:      "Blog",
+		Server:     "nginx/1.24.0",
+		Header:     http.Header{"Server": {"nginx/1.24.0"}},
+		Body:       []byte(`<meta name="generator" content="WordPress 6.4.2" /><link rel="stylesheet" href="/wp-content/themes/a.css"><script src="/wp-includes/js/jquery/jquery.min.js"></script>`),
+	}
+	fetch := func(ctx context.Context, location string) ([]byte, error) {
+		return []byte("/*! jQuery v3.7.1 | (c) OpenJS Foundation */"), nil
+	}
+	assert.Equal(t, []WebApp{
+		{Name: "wordpress", Version: "6.4.2"},
+		{Name: "jquery", Version: "3.7.1"},
+		{Name: "nginx", Version: "1.24.0"},
+	}, loaded.Fingerprint(context.Background(), page, fetch))
+
+	page = &HTTPInfo{
+		URL:        "http://127.0.0.1:8080/",
+		StatusCode: 200,
+		Title:      "Apache Tomcat/9.0.83",
+		Header:     http.Header{"Content-Type": {"text/html"}},
+	}
+	assert.Equal(t, []WebApp{{Name: "apache-tomcat", Version: "9.0.83"}}, loaded.Fingerprint(context.Background(), page, nil))
+
+	page = &HTTPInfo{
+		URL:        "http://127.0.0.1:8080/login",
+		StatusCode: 403,
+		Header:     http.Header{"X-Jenkins": {"2.426.1"}},
+	}
+	assert.Equal(t, []WebApp{{Name: "jenkins", Version: "2.426.1"}}, loaded.Fingerprint(context.Background(), page, nil))
+}